@@ -2,22 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/lookup"
+	"github.com/mchaffe/cloudprefixes/pkg/snapshot"
 	"github.com/mchaffe/cloudprefixes/pkg/update"
 )
 
-type Results struct {
-	IP   string          `json:"ip"`
-	Info []db.PrefixInfo `json:"info"`
-}
-
 func main() {
 
 	flag.Usage = func() {
@@ -30,64 +32,152 @@ func main() {
 \     |     l     l     |     |  |  |  .  |     |  T   j  l|  |  |
  \____l_____j\___/ \__,_l_____l__j  l__j\_l_____l__j  |____|__j__|`)
 		fmt.Printf("\nUsage\n  %s [OPTION]... [IP ADDRESS]...\n", filepath.Base(os.Args[0]))
+		fmt.Printf("  %s COMMAND [OPTION]...\n", filepath.Base(os.Args[0]))
 		fmt.Println("Search cloud prefixes in database for each IP ADDRESS")
 		fmt.Println("\nWith no IP ADDRESS, read standard input.")
+		fmt.Println("\nCommands:")
+		for _, c := range commands {
+			fmt.Printf("  %-12s %s\n", c.name, c.summary)
+		}
 		fmt.Println("\nOptions:")
 		flag.PrintDefaults()
 	}
 
+	// cancel any in-flight update or lookup on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		if c, ok := findCommand(os.Args[1]); ok {
+			err := c.run(ctx, os.Args[2:])
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	updateData := flag.Bool("update", false, "update all prefixes in database and exit")
 	databasePath := flag.String("dbpath", "./cloudprefixes.db", "path to database file")
+	snapshotPath := flag.String("snapshot", "", "write the database contents to a snapshot file for embedding and exit")
+	exportsPath := flag.String("exports", "", "regenerate the exports listed in a JSON file, after updating when combined with -update, and exit")
+	var categories listFlag
+	flag.Var(&categories, "category", "only report prefixes in these categories, e.g. bot to verify a crawler (comma separated or repeated)")
 
 	flag.Parse()
 
-	manager, err := db.NewPrefixManager(*databasePath)
+	if *updateData || *snapshotPath != "" || *exportsPath != "" {
+		manager, err := db.NewPrefixManager(*databasePath)
+		if err != nil {
+			log.Fatalf("Error creating IP range manager: %v", err)
+		}
+		defer manager.Close()
+
+		if *updateData {
+			u := update.NewUpdateManager(manager)
+			err = u.UpdateAllSourcesContext(ctx)
+			if errors.Is(err, context.Canceled) {
+				log.Println("update cancelled, database unchanged")
+				return
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *snapshotPath != "" {
+			if err := writeSnapshot(ctx, manager, *snapshotPath); err != nil {
+				log.Fatalf("error writing snapshot: %v", err)
+			}
+		}
+		if *exportsPath != "" {
+			if err := runExportJobs(ctx, manager, *exportsPath); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	// without a database file, lookups fall back to the embedded snapshot
+	l, err := lookup.Open(*databasePath)
 	if err != nil {
 		log.Fatalf("Error creating IP range manager: %v", err)
 	}
-	defer manager.Close()
+	defer l.Close()
 
-	if *updateData {
-		u := update.NewUpdateManager(manager)
-		u.UpdateAllSources()
-		return
+	var opts []lookup.Option
+	if len(categories) > 0 {
+		opts = append(opts, lookup.WithCategory(categories...))
 	}
 
 	// read from argument list if supplied otherwise read from stdin
 	if flag.NArg() > 0 {
 		for _, ip := range flag.Args() {
-			found, info, err := manager.ContainsIP(ip)
-			if err != nil {
-				log.Fatalf("error scanning database: %v", err)
-			}
-			if found {
-				b, err := json.Marshal(Results{IP: ip, Info: info})
-				if err != nil {
-					log.Fatalf("error serializing to json: %v", err)
-				}
-				fmt.Println(string(b))
-			}
+			lookupAndPrint(ctx, l, ip, opts...)
 		}
 	} else {
+		// a blocked read on stdin doesn't observe ctx, closing it unblocks the scanner
+		go func() {
+			<-ctx.Done()
+			os.Stdin.Close()
+		}()
+
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
-			ip := scanner.Text()
-			found, info, err := manager.ContainsIP(ip)
-			if err != nil {
-				log.Fatalf("error scanning database: %v", err)
-			}
-			if found {
-				b, err := json.Marshal(Results{IP: ip, Info: info})
-				if err != nil {
-					log.Fatalf("error serializing to json: %v", err)
-				}
-				fmt.Println(string(b))
-			}
+			lookupAndPrint(ctx, l, scanner.Text(), opts...)
 		}
 
+		if ctx.Err() != nil {
+			return
+		}
 		if err = scanner.Err(); err != nil {
 			log.Fatalf("error reading from stdin: %v", err)
 		}
 	}
 
 }
+
+func lookupAndPrint(ctx context.Context, l lookup.Lookuper, ip string, opts ...lookup.Option) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		log.Fatalf("error scanning database: invalid IP address %q", ip)
+	}
+	info, err := l.Lookup(ctx, addr, opts...)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("error scanning database: %v", err)
+	}
+	if len(info) > 0 {
+		b, err := json.Marshal(lookup.Result{IP: addr, Info: info})
+		if err != nil {
+			log.Fatalf("error serializing to json: %v", err)
+		}
+		fmt.Println(string(b))
+	}
+}
+
+func writeSnapshot(ctx context.Context, manager *db.PrefixManager, path string) error {
+	// registry delegations would dwarf the providers' prefixes, while crawlers
+	// stay so lookups can still verify them
+	infos, err := manager.ListPrefixesContext(ctx, db.Filter{ExcludeCategories: []string{db.RegistryCategory}})
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("database is empty, run -update first")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Encode(f, infos); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// command is a subcommand run as `cloudprefixes NAME [OPTION]...`.
+type command struct {
+	name    string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"list", "list the prefixes in the database, optionally aggregated", runList},
+	{"export", "write prefixes in a format used by other tools", runExport},
+	{"overlaps", "report prefixes claimed by more than one platform", runOverlaps},
+	{"stats", "summarise prefix counts, address space and source freshness", runStats},
+	{"lookup-host", "match host names against published domains and their addresses", runLookupHost},
+	{"import-asn", "attribute the prefixes an AS originates in a BGP table to a platform", runImportASN},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// newFlagSet returns a flag set for a subcommand with the shared -dbpath
+// option already defined.
+func newFlagSet(name, args, summary string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Println(strings.TrimSpace(fmt.Sprintf("Usage\n  %s %s [OPTION]... %s", filepath.Base(os.Args[0]), name, args)))
+		fmt.Println(summary)
+		fmt.Println("\nOptions:")
+		flags.PrintDefaults()
+	}
+	databasePath := flags.String("dbpath", "./cloudprefixes.db", "path to database file")
+	return flags, databasePath
+}
+
+// listFlag collects values given as a comma separated list, a repeated flag,
+// or both.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// metaFlag collects repeated KEY=VALUE metadata filters. Values may contain
+// commas, so unlike listFlag each value must be given separately.
+type metaFlag map[string][]string
+
+func (m *metaFlag) String() string {
+	var pairs []string
+	for k, values := range *m {
+		for _, v := range values {
+			pairs = append(pairs, k+"="+v)
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *metaFlag) Set(value string) error {
+	k, v, ok := strings.Cut(value, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	if *m == nil {
+		*m = metaFlag{}
+	}
+	(*m)[k] = append((*m)[k], v)
+	return nil
+}
+
+// filterFlags defines the -platform, -service, -region, -category,
+// -exclude-category and -meta options shared by commands that select a subset
+// of the prefixes. Commands list with the filter's WithDefaults, so registry
+// delegations, crawlers and anonymizers are only included when asked for.
+func filterFlags(flags *flag.FlagSet) *db.Filter {
+	filter := &db.Filter{}
+	flags.Var((*listFlag)(&filter.Platforms), "platform", "only include prefixes of these platforms (comma separated or repeated)")
+	flags.Var((*listFlag)(&filter.Services), "service", "only include prefixes of these services (comma separated or repeated)")
+	flags.Var((*listFlag)(&filter.Regions), "region", "only include prefixes in these regions (comma separated or repeated)")
+	flags.Var((*listFlag)(&filter.Categories), "category", "only include prefixes in these categories, e.g. bot (comma separated or repeated)")
+	flags.Var((*listFlag)(&filter.ExcludeCategories), "exclude-category", "leave out prefixes in these categories, anonymizer, bot and registry unless -platform, -service, -category or this is given (comma separated or repeated)")
+	flags.Var((*metaFlag)(&filter.Metadata), "meta", "only include prefixes whose metadata KEY=VALUE, nested keys are separated by dots (repeatable)")
+	return filter
+}
+
+// openExistingDB opens the database at path, refusing to create an empty one
+// in its place.
+func openExistingDB(path string) (*db.PrefixManager, error) {
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("database %s not found, populate it with -update first", path)
+	}
+	return db.NewPrefixManager(path)
+}
+
+// writeOutput calls write with standard output when path is "-". Otherwise the
+// output is written to a temporary file that replaces path once complete, so
+// readers of path never see a partially written file.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/export"
+)
+
+// exportJob describes one export, either from the export command line or an
+// entry in the file given to -exports.
+type exportJob struct {
+	Format string    `json:"format"`
+	Output string    `json:"output"`
+	Name   string    `json:"name,omitempty"`
+	Filter db.Filter `json:"filter"`
+}
+
+func runExport(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("export", "", "Write the prefixes in the database in the chosen FORMAT")
+	format := flags.String("format", "", "output format, one of: "+strings.Join(export.Formats(), ", "))
+	output := flags.String("o", "-", "output file, - writes to standard output")
+	name := flags.String("name", "", "name of the generated set, list or database type")
+	filter := filterFlags(flags)
+	flags.Parse(args)
+
+	if *format == "" {
+		flags.Usage()
+		return fmt.Errorf("missing -format")
+	}
+
+	manager, err := openExistingDB(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	return runExportJob(ctx, manager, exportJob{Format: *format, Output: *output, Name: *name, Filter: *filter})
+}
+
+func runExportJob(ctx context.Context, manager *db.PrefixManager, job exportJob) error {
+	infos, err := manager.ListPrefixesContext(ctx, job.Filter.WithDefaults())
+	if err != nil {
+		return fmt.Errorf("error reading prefixes: %v", err)
+	}
+
+	return writeOutput(job.Output, func(w io.Writer) error {
+		return export.Export(w, job.Format, infos, export.Options{Name: job.Name})
+	})
+}
+
+// runExportJobs regenerates every export listed in the JSON file at path, so
+// generated allowlists can be refreshed after each update.
+func runExportJobs(ctx context.Context, manager *db.PrefixManager, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var jobs []exportJob
+	if err := json.Unmarshal(b, &jobs); err != nil {
+		return fmt.Errorf("error parsing %s: %v", path, err)
+	}
+
+	for _, job := range jobs {
+		if job.Output == "" {
+			return fmt.Errorf("export %s in %s has no output", job.Format, path)
+		}
+		slog.Info("Exporting prefixes", "format", job.Format, "output", job.Output)
+		if err := runExportJob(ctx, manager, job); err != nil {
+			return fmt.Errorf("error exporting %s: %v", job.Output, err)
+		}
+	}
+	return nil
+}
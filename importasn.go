@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/update"
+)
+
+func runImportASN(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("import-asn", "FILE...", "Store the prefixes originated by the -asn AS numbers in each BGP table FILE, an MRT\nRIB dump or pfx2as file optionally compressed with gzip or bzip2, under -platform.\nImports are applied again from their files by -update.")
+	platform := flags.String("platform", "", "platform to attribute the prefixes to, e.g. Hetzner")
+	var asnList listFlag
+	flags.Var(&asnList, "asn", "AS numbers originating the platform's prefixes, e.g. AS24940 (comma separated or repeated)")
+	remove := flags.Bool("remove", false, "stop applying the imports of each FILE for -platform on -update and delete their prefixes")
+	flags.Parse(args)
+
+	if *platform == "" {
+		return errors.New("-platform is required")
+	}
+	if *remove {
+		return removeASNImports(ctx, *databasePath, *platform, flags.Args())
+	}
+	if len(asnList) == 0 {
+		return errors.New("-asn is required")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no BGP table file given")
+	}
+	var asns []uint32
+	for _, s := range asnList {
+		asn, err := update.ParseASN(s)
+		if err != nil {
+			return err
+		}
+		asns = append(asns, asn)
+	}
+
+	manager, err := db.NewPrefixManager(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	u := update.NewUpdateManager(manager)
+	for _, path := range flags.Args() {
+		if err := u.ImportASNPrefixesContext(ctx, path, *platform, asns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeASNImports(ctx context.Context, databasePath, platform string, paths []string) error {
+	if len(paths) == 0 {
+		return errors.New("no BGP table file given")
+	}
+	manager, err := db.NewPrefixManager(databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	for _, path := range paths {
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if err := manager.DeleteASNImportContext(ctx, path, platform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
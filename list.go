@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
+)
+
+func runList(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("list", "", "List the prefixes in the database, one CIDR per line")
+	aggregate := flags.Bool("aggregate", false, "collapse the prefixes into the minimal list of covering CIDRs")
+	asJSON := flags.Bool("json", false, "print every matching entry as JSON instead of distinct CIDRs")
+	filter := filterFlags(flags)
+	flags.Parse(args)
+
+	if *aggregate && *asJSON {
+		return fmt.Errorf("-aggregate and -json can't be combined, aggregated CIDRs have no single attribution")
+	}
+
+	manager, err := openExistingDB(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	infos, err := manager.ListPrefixesContext(ctx, filter.WithDefaults())
+	if err != nil {
+		return fmt.Errorf("error reading prefixes: %v", err)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	if *asJSON {
+		enc := json.NewEncoder(w)
+		for _, info := range infos {
+			if err := enc.Encode(info); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	seen := map[netip.Prefix]bool{}
+	prefixes := []netip.Prefix{}
+	for _, info := range infos {
+		p, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		if p = p.Masked(); !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	if *aggregate {
+		prefixes = cidr.Aggregate(prefixes)
+	} else {
+		cidr.Sort(prefixes)
+	}
+	for _, p := range prefixes {
+		fmt.Fprintln(w, p)
+	}
+	return nil
+}
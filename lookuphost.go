@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/lookup"
+)
+
+// hostResult is the output of lookup-host for one host name.
+type hostResult struct {
+	Host      string          `json:"host"`
+	Domains   []db.DomainInfo `json:"domains,omitempty"`
+	Addresses []lookup.Result `json:"addresses,omitempty"`
+}
+
+func runLookupHost(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("lookup-host", "[HOST]...", "Match each HOST against the domains published by platforms, and with -resolve\nthe prefixes of its addresses. With no HOST, read standard input.")
+	resolve := flags.Bool("resolve", false, "resolve each host and look up its addresses too")
+	resolverAddr := flags.String("resolver", "", "DNS server to resolve with as HOST[:PORT], implies -resolve (default system resolver)")
+	timeout := flags.Duration("timeout", 5*time.Second, "time allowed to resolve each host")
+	flags.Parse(args)
+
+	var resolver *net.Resolver
+	if *resolve || *resolverAddr != "" {
+		resolver = newResolver(*resolverAddr)
+	}
+
+	manager, err := openExistingDB(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	lookupHost := func(host string) error {
+		r, err := matchHost(ctx, manager, resolver, *timeout, host)
+		if err != nil {
+			return err
+		}
+		if len(r.Domains) == 0 && len(r.Addresses) == 0 {
+			return nil
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("error serializing to json: %v", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	if flags.NArg() > 0 {
+		for _, host := range flags.Args() {
+			if err := lookupHost(host); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// a blocked read on stdin doesn't observe ctx, closing it unblocks the scanner
+	go func() {
+		<-ctx.Done()
+		os.Stdin.Close()
+	}()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if err := lookupHost(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading from stdin: %v", err)
+	}
+	return nil
+}
+
+// matchHost matches host against the stored domains and, when resolver is
+// set, looks up the prefixes of each address it resolves to. A host that
+// doesn't resolve is reported with its domain matches only.
+func matchHost(ctx context.Context, manager *db.PrefixManager, resolver *net.Resolver, timeout time.Duration, host string) (hostResult, error) {
+	r := hostResult{Host: host}
+	var err error
+	r.Domains, err = manager.MatchDomainContext(ctx, host)
+	if err != nil {
+		return r, fmt.Errorf("error matching domains: %v", err)
+	}
+	if resolver == nil {
+		return r, nil
+	}
+
+	resolveCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := resolver.LookupNetIP(resolveCtx, "ip", host)
+	if ctx.Err() != nil {
+		return r, ctx.Err()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error resolving %s: %v\n", host, err)
+		return r, nil
+	}
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		_, info, err := manager.ContainsIPContext(ctx, addr.String())
+		if err != nil {
+			return r, fmt.Errorf("error scanning database: %v", err)
+		}
+		if len(info) > 0 {
+			r.Addresses = append(r.Addresses, lookup.Result{IP: addr, Info: info})
+		}
+	}
+	return r, nil
+}
+
+// newResolver returns the system resolver, or one that queries server when it
+// is set. Port 53 is used when server doesn't include one.
+func newResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mchaffe/cloudprefixes/pkg/report"
+)
+
+func runOverlaps(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("overlaps", "", "Report prefixes claimed by more than one platform")
+	format := flags.String("format", "table", "output format, table or json")
+	filter := filterFlags(flags)
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use table or json", *format)
+	}
+
+	manager, err := openExistingDB(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	infos, err := manager.ListPrefixesContext(ctx, filter.WithDefaults())
+	if err != nil {
+		return fmt.Errorf("error reading prefixes: %v", err)
+	}
+	r, err := report.Overlaps(infos)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PLATFORMS\tRELATION\tOVERLAPS")
+	for _, s := range r.Summary {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", strings.Join(s.Platforms[:], " / "), s.Relation, s.Overlaps)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "RELATION\tPREFIX\tPLATFORM\tSERVICES\tINNER PREFIX\tINNER PLATFORM\tINNER SERVICES")
+	for _, o := range r.Overlaps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			o.Relation,
+			o.Outer.Prefix, o.Outer.Platform, strings.Join(o.Outer.Services, ","),
+			o.Inner.Prefix, o.Inner.Platform, strings.Join(o.Inner.Services, ","),
+		)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,150 @@
+// Package cidr provides operations on sets of CIDR prefixes.
+package cidr
+
+import (
+	"net/netip"
+	"sort"
+)
+
+// Sort orders prefixes by address and then by length, shortest first, with
+// IPv4 before IPv6.
+func Sort(prefixes []netip.Prefix) {
+	sort.Slice(prefixes, func(i, j int) bool {
+		if c := prefixes[i].Addr().Compare(prefixes[j].Addr()); c != 0 {
+			return c < 0
+		}
+		return prefixes[i].Bits() < prefixes[j].Bits()
+	})
+}
+
+// Aggregate returns the minimal sorted list of prefixes covering exactly the
+// same addresses as prefixes. Prefixes contained in another are dropped and
+// adjacent halves are joined into their parent until nothing more can be
+// combined. Host bits are masked off and the input slice is reordered.
+func Aggregate(prefixes []netip.Prefix) []netip.Prefix {
+	for i, p := range prefixes {
+		prefixes[i] = p.Masked()
+	}
+	Sort(prefixes)
+
+	result := []netip.Prefix{}
+	for _, p := range prefixes {
+		if n := len(result); n > 0 && result[n-1].Overlaps(p) {
+			// sorted order means the earlier prefix is the shorter one
+			continue
+		}
+		result = append(result, p)
+		for len(result) >= 2 {
+			parent, ok := siblings(result[len(result)-2], result[len(result)-1])
+			if !ok {
+				break
+			}
+			result = append(result[:len(result)-2], parent)
+		}
+	}
+	return result
+}
+
+// siblings reports whether a and b are the two halves of the same parent
+// prefix, and returns the parent.
+func siblings(a, b netip.Prefix) (netip.Prefix, bool) {
+	if a.Bits() != b.Bits() || a.Bits() == 0 || a.Addr().Is4() != b.Addr().Is4() || a == b {
+		return netip.Prefix{}, false
+	}
+	pa, _ := a.Addr().Prefix(a.Bits() - 1)
+	pb, _ := b.Addr().Prefix(b.Bits() - 1)
+	return pa, pa == pb
+}
+
+// SplitFamilies separates prefixes into IPv4 and IPv6, keeping their order.
+func SplitFamilies(prefixes []netip.Prefix) (v4 []netip.Prefix, v6 []netip.Prefix) {
+	for _, p := range prefixes {
+		if p.Addr().Is4() {
+			v4 = append(v4, p)
+		} else {
+			v6 = append(v6, p)
+		}
+	}
+	return v4, v6
+}
+
+// Subtract returns the minimal sorted list of prefixes covering the addresses
+// in prefixes that are not in exclude. Neither input is modified.
+func Subtract(prefixes, exclude []netip.Prefix) []netip.Prefix {
+	include := Aggregate(append([]netip.Prefix(nil), prefixes...))
+	exclude = Aggregate(append([]netip.Prefix(nil), exclude...))
+
+	result := []netip.Prefix{}
+	for _, p := range include {
+		result = append(result, subtract(p, exclude)...)
+	}
+	return result
+}
+
+// subtract splits p in half until each part either misses every prefix in
+// exclude and is kept, or is inside one and is dropped.
+func subtract(p netip.Prefix, exclude []netip.Prefix) []netip.Prefix {
+	var overlapping []netip.Prefix
+	for _, e := range exclude {
+		if !e.Overlaps(p) {
+			continue
+		}
+		if e.Bits() <= p.Bits() {
+			return nil
+		}
+		overlapping = append(overlapping, e)
+	}
+	if len(overlapping) == 0 {
+		return []netip.Prefix{p}
+	}
+	lo, hi := halves(p)
+	return append(subtract(lo, overlapping), subtract(hi, overlapping)...)
+}
+
+// halves returns the two prefixes one bit longer than p that make it up.
+func halves(p netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := p.Bits()
+	lo := netip.PrefixFrom(p.Addr(), bits+1)
+	b := p.Addr().AsSlice()
+	b[bits/8] |= 0x80 >> (bits % 8)
+	addr, _ := netip.AddrFromSlice(b)
+	return lo, netip.PrefixFrom(addr, bits+1)
+}
+
+// FromRange returns the minimal sorted list of prefixes covering the
+// addresses from first to last inclusive. It returns nil if the addresses are
+// of different families or last is before first.
+func FromRange(first, last netip.Addr) []netip.Prefix {
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return nil
+	}
+	var result []netip.Prefix
+	for {
+		// the largest prefix starting at first that ends by last
+		p := netip.PrefixFrom(first, first.BitLen())
+		for p.Bits() > 0 {
+			parent, _ := first.Prefix(p.Bits() - 1)
+			if parent.Addr() != first || lastAddr(parent).Compare(last) > 0 {
+				break
+			}
+			p = parent
+		}
+		result = append(result, p)
+
+		end := lastAddr(p)
+		if end == last {
+			return result
+		}
+		first = end.Next()
+	}
+}
+
+// lastAddr returns the last address in p.
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Masked().Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
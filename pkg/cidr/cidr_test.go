@@ -0,0 +1,120 @@
+package cidr
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []string
+		want []string
+	}{
+		{"Empty", []string{}, nil},
+		{"Host bits", []string{"10.0.0.1/24"}, []string{"10.0.0.0/24"}},
+		{"Duplicates", []string{"10.0.0.0/24", "10.0.0.0/24"}, []string{"10.0.0.0/24"}},
+		{"Nested", []string{"10.0.1.0/24", "10.0.0.0/16"}, []string{"10.0.0.0/16"}},
+		{"Adjacent halves", []string{"10.0.1.0/24", "10.0.0.0/24"}, []string{"10.0.0.0/23"}},
+		{"Cascading merge", []string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/23"}, []string{"10.0.0.0/22"}},
+		{"Adjacent but not halves", []string{"10.0.1.0/24", "10.0.2.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/24"}},
+		{"IPv6", []string{"2001:db8::/33", "2001:db8:8000::/33", "2001:db8:1::/48"}, []string{"2001:db8::/32"}},
+		{"Mixed families", []string{"0.0.0.0/1", "::/1"}, []string{"0.0.0.0/1", "::/1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in []netip.Prefix
+			for _, s := range tt.in {
+				in = append(in, netip.MustParsePrefix(s))
+			}
+			var got []string
+			for _, p := range Aggregate(in) {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Aggregate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitFamilies(t *testing.T) {
+	in := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("192.0.2.0/24"),
+	}
+	v4, v6 := SplitFamilies(in)
+	if len(v4) != 2 || len(v6) != 1 {
+		t.Errorf("SplitFamilies() = %v, %v", v4, v6)
+	}
+}
+
+func TestSubtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []string
+		exclude []string
+		want    []string
+	}{
+		{"Nothing excluded", []string{"10.0.0.0/24"}, nil, []string{"10.0.0.0/24"}},
+		{"Disjoint", []string{"10.0.0.0/24"}, []string{"10.0.1.0/24"}, []string{"10.0.0.0/24"}},
+		{"All excluded", []string{"10.0.0.0/24"}, []string{"10.0.0.0/16"}, nil},
+		{"Identical", []string{"10.0.0.0/24"}, []string{"10.0.0.0/24"}, nil},
+		{"Half", []string{"10.0.0.0/24"}, []string{"10.0.0.128/25"}, []string{"10.0.0.0/25"}},
+		{"Hole", []string{"10.0.0.0/24"}, []string{"10.0.0.64/26"}, []string{"10.0.0.0/26", "10.0.0.128/25"}},
+		{"Two holes", []string{"10.0.0.0/22"}, []string{"10.0.0.0/24", "10.0.3.0/24"}, []string{"10.0.1.0/24", "10.0.2.0/24"}},
+		{"Single address", []string{"10.0.0.0/30"}, []string{"10.0.0.2/32"}, []string{"10.0.0.0/31", "10.0.0.3/32"}},
+		{"IPv6", []string{"2001:db8::/32"}, []string{"2001:db8::/33"}, []string{"2001:db8:8000::/33"}},
+		{"Other family untouched", []string{"10.0.0.0/8", "2001:db8::/32"}, []string{"::/0"}, []string{"10.0.0.0/8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var in, exclude []netip.Prefix
+			for _, s := range tt.in {
+				in = append(in, netip.MustParsePrefix(s))
+			}
+			for _, s := range tt.exclude {
+				exclude = append(exclude, netip.MustParsePrefix(s))
+			}
+			var got []string
+			for _, p := range Subtract(in, exclude) {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Subtract() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromRange(t *testing.T) {
+	tests := []struct {
+		name        string
+		first, last string
+		want        []string
+	}{
+		{"Single address", "192.0.2.1", "192.0.2.1", []string{"192.0.2.1/32"}},
+		{"Aligned", "2.56.0.0", "2.56.3.255", []string{"2.56.0.0/22"}},
+		{"Full /24", "192.0.2.0", "192.0.2.255", []string{"192.0.2.0/24"}},
+		{"Not a power of two", "198.51.100.0", "198.51.102.255", []string{"198.51.100.0/23", "198.51.102.0/24"}},
+		{"Unaligned start", "10.0.0.1", "10.0.0.6", []string{"10.0.0.1/32", "10.0.0.2/31", "10.0.0.4/31", "10.0.0.6/32"}},
+		{"Everything", "0.0.0.0", "255.255.255.255", []string{"0.0.0.0/0"}},
+		{"Top of the space", "255.255.255.254", "255.255.255.255", []string{"255.255.255.254/31"}},
+		{"IPv6", "2001:db8::", "2001:db8:1:ffff:ffff:ffff:ffff:ffff", []string{"2001:db8::/47"}},
+		{"Reversed", "10.0.0.2", "10.0.0.1", nil},
+		{"Mixed families", "10.0.0.1", "::1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, p := range FromRange(netip.MustParseAddr(tt.first), netip.MustParseAddr(tt.last)) {
+				got = append(got, p.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FromRange() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+// ASNImport is a locally supplied BGP table whose prefixes originated by ASNs
+// are stored under Platform. Imports are kept when the database is cleared,
+// so an update can apply them again.
+type ASNImport struct {
+	Path     string   `json:"path"`
+	Platform string   `json:"platform"`
+	ASNs     []uint32 `json:"asns"`
+}
+
+func (m *PrefixManager) SetASNImport(imp ASNImport) error {
+	return m.SetASNImportContext(context.Background(), imp)
+}
+
+// SetASNImportContext records imp, replacing any previous import of the same
+// path for the same platform.
+func (m *PrefixManager) SetASNImportContext(ctx context.Context, imp ASNImport) error {
+	asns, err := json.Marshal(imp.ASNs)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.ExecContext(ctx, `
+        INSERT OR REPLACE INTO asn_imports (path, platform, asns)
+        VALUES (?, ?, ?)`, imp.Path, imp.Platform, string(asns))
+	return err
+}
+
+func (m *PrefixManager) DeleteASNImport(path, platform string) error {
+	return m.DeleteASNImportContext(context.Background(), path, platform)
+}
+
+// DeleteASNImportContext forgets the import of path for platform, so updates
+// no longer apply it, and deletes the prefixes and source it stored.
+func (m *PrefixManager) DeleteASNImportContext(ctx context.Context, path, platform string) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteASNPrefixes(ctx, tx, path, platform); err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM sources WHERE url = ? AND platform = ?",
+		"DELETE FROM asn_imports WHERE path = ? AND platform = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, path, platform); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *PrefixManager) ReplaceASNPrefixes(path, platform string, infos []PrefixInfo) error {
+	return m.ReplaceASNPrefixesContext(context.Background(), path, platform, infos)
+}
+
+// ReplaceASNPrefixesContext stores infos imported from path for platform in
+// a single transaction, deleting the prefixes of an earlier import of path
+// that it no longer lists. Each of infos must have path as its source
+// metadata.
+func (m *PrefixManager) ReplaceASNPrefixesContext(ctx context.Context, path, platform string, infos []PrefixInfo) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteASNPrefixes(ctx, tx, path, platform); err != nil {
+		return err
+	}
+	if err := addPrefixes(ctx, tx, infos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteASNPrefixes deletes the prefixes imported from path for platform,
+// which are told apart by their source metadata.
+func deleteASNPrefixes(ctx context.Context, tx *sql.Tx, path, platform string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM cloud_prefixes WHERE platform = ? AND json_extract(metadata, '$.source') = ?", platform, path)
+	return err
+}
+
+func (m *PrefixManager) ListASNImports() ([]ASNImport, error) {
+	return m.ListASNImportsContext(context.Background())
+}
+
+// ListASNImportsContext returns every recorded import ordered by platform and
+// path.
+func (m *PrefixManager) ListASNImportsContext(ctx context.Context) ([]ASNImport, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT path, platform, asns FROM asn_imports ORDER BY platform, path")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []ASNImport{}
+	for rows.Next() {
+		var imp ASNImport
+		var asns string
+		if err := rows.Scan(&imp.Path, &imp.Platform, &asns); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(asns), &imp.ASNs); err != nil {
+			return nil, fmt.Errorf("invalid AS numbers for %s: %v", imp.Path, err)
+		}
+		imports = append(imports, imp)
+	}
+	return imports, rows.Err()
+}
@@ -0,0 +1,52 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixManager_ASNImports(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	defer manager.Close()
+
+	imports := []ASNImport{
+		{Path: "/data/rib.bz2", Platform: "Hetzner", ASNs: []uint32{24940}},
+		{Path: "/data/rib.bz2", Platform: "OVH", ASNs: []uint32{16276}},
+		{Path: "/data/pfx2as.gz", Platform: "Hetzner", ASNs: []uint32{24940, 213230}},
+		// replaces the first import
+		{Path: "/data/rib.bz2", Platform: "Hetzner", ASNs: []uint32{24940, 213230}},
+	}
+	for _, imp := range imports {
+		if err := manager.SetASNImport(imp); err != nil {
+			t.Fatalf("PrefixManager.SetASNImport() error = %v", err)
+		}
+	}
+	ovh := []PrefixInfo{{Prefix: "51.68.0.0/16", Platform: "OVH", Metadata: Metadata{"source": "/data/rib.bz2"}}}
+	if err := manager.ReplaceASNPrefixes("/data/rib.bz2", "OVH", ovh); err != nil {
+		t.Fatalf("PrefixManager.ReplaceASNPrefixes() error = %v", err)
+	}
+	if err := manager.DeleteASNImport("/data/rib.bz2", "OVH"); err != nil {
+		t.Fatalf("PrefixManager.DeleteASNImport() error = %v", err)
+	}
+	if found, _, err := manager.ContainsIP("51.68.0.1"); err != nil || found {
+		t.Errorf("PrefixManager.DeleteASNImport() left prefixes, found = %v, error = %v", found, err)
+	}
+	if err := manager.ClearAllData(); err != nil {
+		t.Fatalf("PrefixManager.ClearAllData() error = %v", err)
+	}
+
+	got, err := manager.ListASNImports()
+	if err != nil {
+		t.Fatalf("PrefixManager.ListASNImports() error = %v", err)
+	}
+	want := []ASNImport{
+		{Path: "/data/pfx2as.gz", Platform: "Hetzner", ASNs: []uint32{24940, 213230}},
+		{Path: "/data/rib.bz2", Platform: "Hetzner", ASNs: []uint32{24940, 213230}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixManager.ListASNImports() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DomainInfo is a domain name a platform publishes as used by one of its
+// services, such as the domains GitHub Actions runners need to reach.
+// Domains may be wildcards like *.github.com, which are stored as published.
+type DomainInfo struct {
+	Domain   string   `json:"domain"`
+	Platform string   `json:"platform"`
+	Region   *string  `json:"region,omitempty"`
+	Service  *string  `json:"service,omitempty"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+func (m *PrefixManager) AddDomainBatch(infos []DomainInfo) error {
+	return m.AddDomainBatchContext(context.Background(), infos)
+}
+
+// AddDomainBatchContext inserts all infos in a single transaction, replacing
+// any existing entry for the same domain, platform, service and region. Domains are
+// stored in lower case without a trailing dot.
+func (m *PrefixManager) AddDomainBatchContext(ctx context.Context, infos []DomainInfo) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+        INSERT OR REPLACE INTO domains (domain, platform, region, service, metadata)
+        VALUES (?, ?, ?, ?, ?)
+    `)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, info := range infos {
+		domain := normaliseDomain(info.Domain)
+		if domain == "" {
+			return fmt.Errorf("invalid domain %q", info.Domain)
+		}
+		_, err = stmt.ExecContext(ctx, domain, info.Platform, info.Region, info.Service, info.Metadata)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (m *PrefixManager) FindDomain(domain string) ([]DomainInfo, error) {
+	return m.FindDomainContext(context.Background(), domain)
+}
+
+// FindDomainContext returns the entries stored for exactly domain, ignoring
+// case and a trailing dot, ordered by platform, service and region.
+func (m *PrefixManager) FindDomainContext(ctx context.Context, domain string) ([]DomainInfo, error) {
+	rows, err := m.db.QueryContext(ctx, `
+        SELECT domain, platform, region, service, metadata FROM domains
+        WHERE domain = ?
+        ORDER BY platform, service, region`, normaliseDomain(domain))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []DomainInfo{}
+	for rows.Next() {
+		var info DomainInfo
+		if err := rows.Scan(&info.Domain, &info.Platform, &info.Region, &info.Service, &info.Metadata); err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, rows.Err()
+}
+
+func (m *PrefixManager) MatchDomain(host string) ([]DomainInfo, error) {
+	return m.MatchDomainContext(context.Background(), host)
+}
+
+// MatchDomainContext returns the entries whose domain matches host, either
+// exactly or as a wildcard, ordered by platform, service, region and domain.
+// A leading "*" label matches one or more labels, so *.github.com matches
+// api.github.com and codeload.api.github.com but not github.com. A "*"
+// anywhere else matches within a single label, e.g. *-my.sharepoint.com.
+func (m *PrefixManager) MatchDomainContext(ctx context.Context, host string) ([]DomainInfo, error) {
+	host = normaliseDomain(host)
+	rows, err := m.db.QueryContext(ctx, `
+        SELECT domain, platform, region, service, metadata FROM domains
+        WHERE domain = ? OR instr(domain, '*') > 0
+        ORDER BY platform, service, region, domain`, host)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	infos := []DomainInfo{}
+	for rows.Next() {
+		var info DomainInfo
+		if err := rows.Scan(&info.Domain, &info.Platform, &info.Region, &info.Service, &info.Metadata); err != nil {
+			return nil, err
+		}
+		if domainMatches(info.Domain, host) {
+			infos = append(infos, info)
+		}
+	}
+	return infos, rows.Err()
+}
+
+// domainMatches reports whether the normalised host matches pattern.
+func domainMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	patternLabels := strings.Split(pattern, ".")
+	hostLabels := strings.Split(host, ".")
+	if patternLabels[0] == "*" && len(patternLabels) > 1 {
+		// the leading wildcard absorbs any extra labels of host
+		if len(hostLabels) < len(patternLabels) {
+			return false
+		}
+		patternLabels = patternLabels[1:]
+		hostLabels = hostLabels[len(hostLabels)-len(patternLabels):]
+	} else if len(hostLabels) != len(patternLabels) {
+		return false
+	}
+	for i, p := range patternLabels {
+		if ok, err := path.Match(p, hostLabels[i]); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func normaliseDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPrefixManager_FindDomain(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.AddDomainBatch([]DomainInfo{
+		{Domain: "*.github.com", Platform: "GitHub", Service: stringPointer("website")},
+		{Domain: "*.github.com", Platform: "GitHub", Service: stringPointer("copilot")},
+		{Domain: "NPM.pkg.github.com.", Platform: "GitHub", Service: stringPointer("packages")},
+		{Domain: "tuf-repo.github.com", Platform: "GitHub", Service: stringPointer("artifact_attestations"), Metadata: Metadata{"trust_domain": "example"}},
+	})
+	if err != nil {
+		t.Fatalf("PrefixManager.AddDomainBatch() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		domain string
+		want   []DomainInfo
+	}{
+		{
+			"Wildcard stored as published",
+			"*.github.com",
+			[]DomainInfo{
+				{Domain: "*.github.com", Platform: "GitHub", Service: stringPointer("copilot")},
+				{Domain: "*.github.com", Platform: "GitHub", Service: stringPointer("website")},
+			},
+		},
+		{
+			"Case and trailing dot ignored",
+			"npm.PKG.github.com.",
+			[]DomainInfo{{Domain: "npm.pkg.github.com", Platform: "GitHub", Service: stringPointer("packages")}},
+		},
+		{
+			"Metadata",
+			"tuf-repo.github.com",
+			[]DomainInfo{{Domain: "tuf-repo.github.com", Platform: "GitHub", Service: stringPointer("artifact_attestations"), Metadata: Metadata{"trust_domain": "example"}}},
+		},
+		{
+			"Exact match only",
+			"api.github.com",
+			[]DomainInfo{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manager.FindDomain(tt.domain)
+			if err != nil {
+				t.Fatalf("PrefixManager.FindDomain() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrefixManager.FindDomain() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if err := manager.AddDomainBatch([]DomainInfo{{Domain: " ", Platform: "GitHub"}}); err == nil {
+		t.Errorf("PrefixManager.AddDomainBatch() expected error for empty domain")
+	}
+	if err := manager.ClearAllData(); err != nil {
+		t.Fatalf("PrefixManager.ClearAllData() error = %v", err)
+	}
+	if got, _ := manager.FindDomain("*.github.com"); len(got) != 0 {
+		t.Errorf("PrefixManager.FindDomain() = %+v after ClearAllData, want none", got)
+	}
+}
+
+func TestPrefixManager_MatchDomain(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.AddDomainBatch([]DomainInfo{
+		{Domain: "*.blob.core.windows.net", Platform: "GitHub", Service: stringPointer("actions")},
+		{Domain: "npmregistryv2prod.blob.core.windows.net", Platform: "GitHub", Service: stringPointer("packages")},
+		{Domain: "*.github.com", Platform: "GitHub", Service: stringPointer("website")},
+		{Domain: "*-my.sharepoint.com", Platform: "Microsoft 365", Service: stringPointer("SharePoint")},
+	})
+	if err != nil {
+		t.Fatalf("PrefixManager.AddDomainBatch() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		host string
+		want []string
+	}{
+		{"Exact and wildcard", "npmregistryv2prod.blob.core.windows.net", []string{"*.blob.core.windows.net", "npmregistryv2prod.blob.core.windows.net"}},
+		{"Wildcard", "example.blob.core.windows.net", []string{"*.blob.core.windows.net"}},
+		{"Wildcard matches several labels", "codeload.api.github.com.", []string{"*.github.com"}},
+		{"Wildcard needs a label", "github.com", nil},
+		{"Wildcard within label", "contoso-my.SharePoint.com", []string{"*-my.sharepoint.com"}},
+		{"Wildcard within label is one label", "a.contoso-my.sharepoint.com", nil},
+		{"No match", "example.com", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := manager.MatchDomain(tt.host)
+			if err != nil {
+				t.Fatalf("PrefixManager.MatchDomain() error = %v", err)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Domain)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PrefixManager.MatchDomain() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
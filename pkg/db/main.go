@@ -1,25 +1,131 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"math/big"
 	"net"
+	"sort"
+	"strings"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
 
+// PrefixInfo is a prefix published by a platform. Category groups prefixes
+// by purpose across platforms, e.g. BotCategory for verified crawlers.
 type PrefixInfo struct {
-	Prefix   string  `json:"prefix"`
-	Platform string  `json:"platform"`
-	Region   *string `json:"region,omitempty"`
-	Service  *string `json:"service,omitempty"`
-	Metadata *string `json:"metadata,omitempty"`
+	Prefix   string   `json:"prefix"`
+	Platform string   `json:"platform"`
+	Region   *string  `json:"region,omitempty"`
+	Service  *string  `json:"service,omitempty"`
+	Category *string  `json:"category,omitempty"`
+	Metadata Metadata `json:"metadata,omitempty"`
+}
+
+// BotCategory is the category of prefixes that search engine and AI crawlers
+// are published to send requests from, to verify that a request claiming to
+// be from a crawler really came from its vendor.
+const BotCategory = "bot"
+
+// AnonymizerCategory is the category of prefixes that relay traffic for
+// others to hide where it came from, such as Tor exit nodes and iCloud Private
+// Relay egress ranges.
+const AnonymizerCategory = "anonymizer"
+
+// Metadata holds the source specific details of a prefix, such as the AWS
+// network border group or geofeed location. It is stored as a JSON object, so
+// values read from the database have the types encoding/json decodes into.
+type Metadata map[string]any
+
+// Value implements driver.Valuer, storing nil as NULL.
+func (m Metadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata: %v", err)
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (m *Metadata) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported metadata type %T", src)
+	}
+	*m = nil
+	if err := json.Unmarshal(b, m); err != nil {
+		return fmt.Errorf("invalid metadata: %v", err)
+	}
+	return nil
+}
+
+// Filter restricts the prefixes returned by ListPrefixes. Each non-empty
+// field limits results to rows matching one of its values. Metadata maps keys
+// to accepted values, where nested keys are separated by dots, e.g.
+// location.country_code. ExcludeCategories drops rows in any of its
+// categories.
+type Filter struct {
+	Platforms         []string            `json:"platforms,omitempty"`
+	Services          []string            `json:"services,omitempty"`
+	Regions           []string            `json:"regions,omitempty"`
+	Categories        []string            `json:"categories,omitempty"`
+	ExcludeCategories []string            `json:"exclude_categories,omitempty"`
+	Metadata          map[string][]string `json:"metadata,omitempty"`
+}
+
+// RegistryCategory is the category of prefixes attributed from regional
+// internet registry delegations rather than a provider. They cover nearly
+// every routed address, so are left out of listings unless asked for.
+const RegistryCategory = "registry"
+
+// DefaultExcludedCategories are the categories WithDefaults excludes, which
+// don't belong in an allowlist of the providers' prefixes.
+var DefaultExcludedCategories = []string{AnonymizerCategory, BotCategory, RegistryCategory}
+
+// WithDefaults returns f excluding DefaultExcludedCategories when it selects
+// no platform, service or category and excludes no category itself, for
+// listings such as exports where registry delegations would swamp the
+// providers' prefixes. A filter naming the rows it wants, e.g. the RIR
+// platform, gets them. Lookups don't apply it, so they still report the
+// registered country.
+func (f Filter) WithDefaults() Filter {
+	if len(f.Platforms) == 0 && len(f.Services) == 0 && len(f.Categories) == 0 && len(f.ExcludeCategories) == 0 {
+		f.ExcludeCategories = DefaultExcludedCategories
+	}
+	return f
+}
+
+// Source records the last successful fetch of prefixes from a URL.
+// Published is the creation time or version reported by the source itself,
+// and SyncToken the token some sources publish to tell whether the data has
+// changed, for sources that include them.
+type Source struct {
+	URL       string    `json:"url"`
+	Platform  string    `json:"platform"`
+	Prefixes  int       `json:"prefixes"`
+	Published *string   `json:"published,omitempty"`
+	SyncToken *string   `json:"sync_token,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type PrefixManager struct {
-	db *sql.DB
+	db   *sql.DB
+	path string
 }
 
 func NewPrefixManager(dbPath string) (*PrefixManager, error) {
@@ -28,7 +134,7 @@ func NewPrefixManager(dbPath string) (*PrefixManager, error) {
 		return nil, fmt.Errorf("error opening database: %v", err)
 	}
 
-	manager := &PrefixManager{db: db}
+	manager := &PrefixManager{db: db, path: dbPath}
 	err = manager.initDB()
 	if err != nil {
 		db.Close()
@@ -39,62 +145,55 @@ func NewPrefixManager(dbPath string) (*PrefixManager, error) {
 }
 
 func (m *PrefixManager) initDB() error {
-	_, err := m.db.Exec(`
-        CREATE TABLE IF NOT EXISTS cloud_prefixes (
-            id INTEGER PRIMARY KEY AUTOINCREMENT,
-			service TEXT,
-			platform TEXT,
-			region TEXT,
-            prefix TEXT,
-			start_ip_high INTEGER,
-            start_ip_low INTEGER,
-            end_ip_high INTEGER,
-            end_ip_low INTEGER,
-			ip_version INTEGER,
-			metadata JSONB
-        )
-    `)
-	return err
+	return m.migrate(context.Background())
 }
 
 func (m *PrefixManager) AddPrefix(info PrefixInfo) error {
-	_, ipNet, err := net.ParseCIDR(info.Prefix)
-	if err != nil {
-		return fmt.Errorf("invalid CIDR: %v", err)
-	}
+	return m.AddPrefixContext(context.Background(), info)
+}
 
-	startIPHigh, startIPLow, err := ipToInts(ipNet.IP)
-	if err != nil {
-		return err
-	}
-	endIPHigh, endIPLow, err := ipToInts(lastIP(ipNet))
+// AddPrefixContext stores info, replacing any existing entry for the same
+// prefix, platform, service and region.
+func (m *PrefixManager) AddPrefixContext(ctx context.Context, info PrefixInfo) error {
+	r, err := parseRange(info.Prefix)
 	if err != nil {
-		return err
-	}
-	ipVersion := 4
-	if ipNet.IP.To4() == nil {
-		ipVersion = 6
+		return fmt.Errorf("invalid CIDR: %v", err)
 	}
 
-	_, err = m.db.Exec(`
+	_, err = m.db.ExecContext(ctx, `
         INSERT OR REPLACE INTO cloud_prefixes 
-        (prefix, start_ip_high, start_ip_low, end_ip_high, end_ip_low, ip_version, region, platform, service, metadata) 
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		info.Prefix, startIPHigh, startIPLow, endIPHigh, endIPLow, ipVersion, info.Region, info.Platform, info.Service, info.Metadata)
+        (prefix, start_ip_high, start_ip_low, end_ip_high, end_ip_low, ip_version, region, platform, service, category, metadata) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		info.Prefix, r.startHigh, r.startLow, r.endHigh, r.endLow, r.version, info.Region, info.Platform, info.Service, info.Category, info.Metadata)
 	return err
 }
 
 func (m *PrefixManager) AddPrefixBatch(infos []PrefixInfo) error {
-	tx, err := m.db.Begin()
+	return m.AddPrefixBatchContext(context.Background(), infos)
+}
+
+// AddPrefixBatchContext inserts all infos in a single transaction, replacing
+// existing entries like AddPrefixContext. If ctx is cancelled before the
+// transaction commits, nothing is inserted.
+func (m *PrefixManager) AddPrefixBatchContext(ctx context.Context, infos []PrefixInfo) error {
+	tx, err := m.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
+	if err := addPrefixes(ctx, tx, infos); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addPrefixes inserts infos as part of tx.
+func addPrefixes(ctx context.Context, tx *sql.Tx, infos []PrefixInfo) error {
+	stmt, err := tx.PrepareContext(ctx, `
         INSERT OR REPLACE INTO cloud_prefixes 
-        (prefix, start_ip_high, start_ip_low, end_ip_high, end_ip_low, ip_version, region, platform, service, metadata) 
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        (prefix, start_ip_high, start_ip_low, end_ip_high, end_ip_low, ip_version, region, platform, service, category, metadata) 
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `)
 	if err != nil {
 		return err
@@ -102,64 +201,74 @@ func (m *PrefixManager) AddPrefixBatch(infos []PrefixInfo) error {
 	defer stmt.Close()
 
 	for _, info := range infos {
-		_, ipNet, err := net.ParseCIDR(info.Prefix)
+		r, err := parseRange(info.Prefix)
 		if err != nil {
 			return fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
 		}
 
-		startIPHigh, startIPLow, err := ipToInts(ipNet.IP)
-		if err != nil {
-			return err
-		}
-		endIPHigh, endIPLow, err := ipToInts(lastIP(ipNet))
-		if err != nil {
-			return err
-		}
-		ipVersion := 4
-		if ipNet.IP.To4() == nil {
-			ipVersion = 6
-		}
-
-		_, err = stmt.Exec(info.Prefix, startIPHigh, startIPLow, endIPHigh, endIPLow, ipVersion, info.Region, info.Platform, info.Service, info.Metadata)
+		_, err = stmt.ExecContext(ctx, info.Prefix, r.startHigh, r.startLow, r.endHigh, r.endLow, r.version, info.Region, info.Platform, info.Service, info.Category, info.Metadata)
 		if err != nil {
 			return err
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
 func (m *PrefixManager) ContainsIP(ip string) (bool, []PrefixInfo, error) {
+	return m.ContainsIPContext(context.Background(), ip)
+}
+
+func (m *PrefixManager) ContainsIPContext(ctx context.Context, ip string) (bool, []PrefixInfo, error) {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
 		return false, []PrefixInfo{}, fmt.Errorf("invalid IP address")
 	}
 
-	ipHigh, ipLow, err := ipToInts(parsedIP)
-	if err != nil {
-		return false, []PrefixInfo{}, err
+	ipVersion, bits := 4, 32
+	if ip4 := parsedIP.To4(); ip4 != nil {
+		parsedIP = ip4
+	} else {
+		ipVersion, bits = 6, 128
 	}
 
-	ipVersion := 4
-	if parsedIP.To4() == nil {
-		ipVersion = 6
+	// A prefix contains the IP only if it is the IP masked to the prefix's
+	// length, so rather than a range scan look up the IP's network at every
+	// length, each of which is a single probe of the start address index.
+	// CROSS JOIN stops SQLite choosing to scan cloud_prefixes instead.
+	var candidates []string
+	args := []any{}
+	for ones := 0; ones <= bits; ones++ {
+		ipNet := &net.IPNet{IP: parsedIP.Mask(net.CIDRMask(ones, bits)), Mask: net.CIDRMask(ones, bits)}
+		r, err := newRange(ipNet)
+		if err != nil {
+			return false, []PrefixInfo{}, err
+		}
+		candidates = append(candidates, "(?, ?, ?, ?)")
+		args = append(args, r.startHigh, r.startLow, r.endHigh, r.endLow)
 	}
+	args = append(args, ipVersion)
 
-	rows, err := m.db.Query(`
-        SELECT prefix, region, platform, service, metadata 
-        FROM cloud_prefixes
-        WHERE start_ip_high <= ? AND start_ip_low <= ? 
-        AND end_ip_high >= ? AND end_ip_low >= ? 
-        AND ip_version = ?`,
-		ipHigh, ipLow, ipHigh, ipLow, ipVersion)
+	rows, err := m.db.QueryContext(ctx, `
+        WITH candidates (start_ip_high, start_ip_low, end_ip_high, end_ip_low) AS (
+            VALUES `+strings.Join(candidates, ", ")+`
+        )
+        SELECT p.prefix, p.region, p.platform, p.service, p.category, p.metadata
+        FROM candidates c
+        CROSS JOIN cloud_prefixes p
+        WHERE p.ip_version = ?
+        AND p.start_ip_high = c.start_ip_high AND p.start_ip_low = c.start_ip_low
+        AND p.end_ip_high = c.end_ip_high AND p.end_ip_low = c.end_ip_low
+        ORDER BY p.id`,
+		args...)
 	if err != nil {
 		return false, []PrefixInfo{}, err
 	}
+	defer rows.Close()
 
 	var results []PrefixInfo
 	for rows.Next() {
 		var info PrefixInfo
-		if err := rows.Scan(&info.Prefix, &info.Region, &info.Platform, &info.Service, &info.Metadata); err != nil {
+		if err := rows.Scan(&info.Prefix, &info.Region, &info.Platform, &info.Service, &info.Category, &info.Metadata); err != nil {
 			return false, []PrefixInfo{}, err
 		}
 		results = append(results, info)
@@ -177,10 +286,136 @@ func (m *PrefixManager) ContainsIP(ip string) (bool, []PrefixInfo, error) {
 	return true, results, nil
 }
 
+func (m *PrefixManager) ListPrefixes(filter Filter) ([]PrefixInfo, error) {
+	return m.ListPrefixesContext(context.Background(), filter)
+}
+
+// ListPrefixesContext returns every stored prefix matching filter, ordered by
+// IP version and start address.
+func (m *PrefixManager) ListPrefixesContext(ctx context.Context, filter Filter) ([]PrefixInfo, error) {
+	query := "SELECT prefix, region, platform, service, category, metadata FROM cloud_prefixes"
+	var where []string
+	var args []any
+	for _, f := range []struct {
+		column string
+		values []string
+	}{
+		{"platform", filter.Platforms},
+		{"service", filter.Services},
+		{"region", filter.Regions},
+		{"category", filter.Categories},
+	} {
+		if len(f.values) == 0 {
+			continue
+		}
+		where = append(where, f.column+" IN (?"+strings.Repeat(", ?", len(f.values)-1)+")")
+		for _, v := range f.values {
+			args = append(args, v)
+		}
+	}
+	if len(filter.ExcludeCategories) > 0 {
+		where = append(where, "(category IS NULL OR category NOT IN (?"+strings.Repeat(", ?", len(filter.ExcludeCategories)-1)+"))")
+		for _, v := range filter.ExcludeCategories {
+			args = append(args, v)
+		}
+	}
+	keys := make([]string, 0, len(filter.Metadata))
+	for k := range filter.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		values := filter.Metadata[k]
+		if len(values) == 0 {
+			continue
+		}
+		// json_extract returns booleans as 1 and 0, so compare them as written
+		where = append(where, `CASE json_type(metadata, ?)
+            WHEN 'true' THEN 'true' WHEN 'false' THEN 'false'
+            ELSE CAST(json_extract(metadata, ?) AS TEXT) END IN (?`+strings.Repeat(", ?", len(values)-1)+")")
+		args = append(args, jsonPath(k), jsonPath(k))
+		for _, v := range values {
+			args = append(args, v)
+		}
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY ip_version, start_ip_high, start_ip_low, id"
+
+	rows, err := m.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []PrefixInfo{}
+	for rows.Next() {
+		var info PrefixInfo
+		if err := rows.Scan(&info.Prefix, &info.Region, &info.Platform, &info.Service, &info.Category, &info.Metadata); err != nil {
+			return nil, err
+		}
+		results = append(results, info)
+	}
+	return results, rows.Err()
+}
+
+// jsonPath returns the SQLite JSON path of a dot separated metadata key.
+func jsonPath(key string) string {
+	path := "$"
+	for _, label := range strings.Split(key, ".") {
+		path += `."` + label + `"`
+	}
+	return path
+}
+
 func (m *PrefixManager) Close() error {
 	return m.db.Close()
 }
 
+// ipRange is the first and last address of a prefix as stored in the
+// database.
+type ipRange struct {
+	version                              int
+	startHigh, startLow, endHigh, endLow int64
+}
+
+func parseRange(prefix string) (ipRange, error) {
+	_, ipNet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return ipRange{}, err
+	}
+	return newRange(ipNet)
+}
+
+func newRange(ipNet *net.IPNet) (ipRange, error) {
+	startHigh, startLow, err := ipToInts(ipNet.IP)
+	if err != nil {
+		return ipRange{}, err
+	}
+	endHigh, endLow, err := ipToInts(lastIP(ipNet))
+	if err != nil {
+		return ipRange{}, err
+	}
+	version := 4
+	if ipNet.IP.To4() == nil {
+		version = 6
+	}
+	return ipRange{
+		version:   version,
+		startHigh: sqlInt(startHigh),
+		startLow:  sqlInt(startLow),
+		endHigh:   sqlInt(endHigh),
+		endLow:    sqlInt(endLow),
+	}, nil
+}
+
+// sqlInt maps v onto SQLite's signed 64bit integers by flipping the sign bit,
+// so the stored values sort in the same order as the addresses.
+func sqlInt(v uint64) int64 {
+	return int64(v ^ 1<<63)
+}
+
 // SQLite doesn't have 128bit integers or dedicated IP type. So in order to store
 // them in a way to make querying efficient, split them into two 64bit integers
 func ipToInts(ip net.IP) (high uint64, low uint64, err error) {
@@ -200,11 +435,7 @@ func ipToInts(ip net.IP) (high uint64, low uint64, err error) {
 		return 0, 0, fmt.Errorf("invalid IP address")
 	}
 
-	ipInt := new(big.Int).SetBytes(ipv6)
-	high = ipInt.Rsh(ipInt, 64).Uint64()
-	low = ipInt.Uint64()
-
-	return high, low, nil
+	return binary.BigEndian.Uint64(ipv6[:8]), binary.BigEndian.Uint64(ipv6[8:]), nil
 }
 
 func lastIP(ipNet *net.IPNet) net.IP {
@@ -217,9 +448,127 @@ func lastIP(ipNet *net.IPNet) net.IP {
 }
 
 func (m *PrefixManager) ClearAllData() error {
-	_, err := m.db.Exec("DELETE FROM cloud_prefixes")
-	if err != nil {
-		return fmt.Errorf("failed to clear database: %v", err)
+	return m.ClearAllDataContext(context.Background())
+}
+
+// dataTables are the tables holding fetched data, which ClearAllData and
+// ReplaceData empty.
+var dataTables = []string{"cloud_prefixes", "domains", "sources"}
+
+// ClearAllDataContext deletes every prefix, domain and source. ASN imports
+// are configuration rather than fetched data and are kept.
+func (m *PrefixManager) ClearAllDataContext(ctx context.Context) error {
+	for _, table := range dataTables {
+		_, err := m.db.ExecContext(ctx, "DELETE FROM "+table)
+		if err != nil {
+			return fmt.Errorf("failed to clear database: %v", err)
+		}
 	}
 	return nil
 }
+
+func (m *PrefixManager) ReplaceData(staged *PrefixManager) error {
+	return m.ReplaceDataContext(context.Background(), staged)
+}
+
+// ReplaceDataContext replaces the contents of the database with those of
+// staged in a single transaction, so a reader sees either the old or the new
+// data and nothing is changed if ctx is cancelled first. staged must be
+// stored in a file rather than :memory:.
+func (m *PrefixManager) ReplaceDataContext(ctx context.Context, staged *PrefixManager) error {
+	// ATTACH only applies to the connection it runs on
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS staged", staged.path)
+	if err != nil {
+		return fmt.Errorf("error attaching %s: %v", staged.path, err)
+	}
+	defer conn.ExecContext(context.Background(), "DETACH DATABASE staged")
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, table := range dataTables {
+		columns, err := tableColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM main."+table); err != nil {
+			return fmt.Errorf("failed to clear database: %v", err)
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO main."+table+" ("+columns+") SELECT "+columns+" FROM staged."+table)
+		if err != nil {
+			return fmt.Errorf("error copying %s: %v", table, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// tableColumns returns the comma separated columns of table.
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) (string, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT name FROM pragma_table_info(?, 'main')", table)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return "", err
+		}
+		columns = append(columns, name)
+	}
+	return strings.Join(columns, ", "), rows.Err()
+}
+
+func (m *PrefixManager) SetSource(source Source) error {
+	return m.SetSourceContext(context.Background(), source)
+}
+
+// SetSourceContext records a fetch of source, replacing any previous record
+// for the same URL.
+func (m *PrefixManager) SetSourceContext(ctx context.Context, source Source) error {
+	_, err := m.db.ExecContext(ctx, `
+        INSERT OR REPLACE INTO sources (url, platform, prefixes, published, sync_token, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		source.URL, source.Platform, source.Prefixes, source.Published, source.SyncToken, source.UpdatedAt.UTC().Format(time.RFC3339))
+	return err
+}
+
+func (m *PrefixManager) ListSources() ([]Source, error) {
+	return m.ListSourcesContext(context.Background())
+}
+
+// ListSourcesContext returns every recorded source ordered by platform and
+// URL.
+func (m *PrefixManager) ListSourcesContext(ctx context.Context) ([]Source, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT url, platform, prefixes, published, sync_token, updated_at FROM sources ORDER BY platform, url")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []Source{}
+	for rows.Next() {
+		var source Source
+		var updatedAt string
+		if err := rows.Scan(&source.URL, &source.Platform, &source.Prefixes, &source.Published, &source.SyncToken, &updatedAt); err != nil {
+			return nil, err
+		}
+		source.UpdatedAt, err = time.Parse(time.RFC3339, updatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid update time for %s: %v", source.URL, err)
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
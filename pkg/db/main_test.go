@@ -1,9 +1,14 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -148,6 +153,15 @@ func TestPrefixManager_ContainsIP(t *testing.T) {
 		t.Fatalf("Failed to add prefix: %v", err)
 	}
 
+	err = manager.AddPrefixBatch([]PrefixInfo{
+		{Prefix: "2001:db8:1::/48", Platform: "Azure", Service: stringPointer("Storage")},
+		{Prefix: "fd00::/8", Platform: "Private"},
+		{Prefix: "fd00:ffff:ffff:ffff:8000::/65", Platform: "Private"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add prefixes: %v", err)
+	}
+
 	tests := []struct {
 		name     string
 		ip       string
@@ -157,6 +171,12 @@ func TestPrefixManager_ContainsIP(t *testing.T) {
 	}{
 		{"IP inside IPv4 range", "192.168.3.10", true, false, 1},
 		{"IP inside IPv6 range", "2001:db8::1", true, false, 1},
+		{"IP inside nested IPv6 ranges", "2001:db8:1::5", true, false, 2},
+		{"IP after nested IPv6 range", "2001:db8:2::1", true, false, 1},
+		{"IP after IPv6 range", "2001:db9::1", false, false, 0},
+		{"IPv6 with high bits set", "fd00:ffff:ffff:ffff:ffff::1", true, false, 2},
+		{"IPv6 before high half of /64", "fd00:ffff:ffff:ffff:7fff::1", true, false, 1},
+		{"IPv4-mapped IPv6", "::ffff:192.168.3.10", true, false, 1},
 		{"IP outside range", "203.0.113.5", false, false, 0},
 		{"Invalid IP", "invalid_ip", false, true, 0},
 	}
@@ -187,7 +207,8 @@ func Test_ipToInts(t *testing.T) {
 		wantErr  bool
 	}{
 		{"IPv4", net.ParseIP("203.0.113.1").To4(), 0, 3405803777, false},
-		{"IPv6", net.ParseIP("2001:db8::1").To16(), 2306139568115548160, 2306139568115548160, false},
+		{"IPv6", net.ParseIP("2001:db8::1").To16(), 2306139568115548160, 1, false},
+		{"IPv6 high bit", net.ParseIP("ff02::ffff:ffff:ffff:ffff").To16(), 18375249429625044992, 18446744073709551615, false},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -279,3 +300,313 @@ func TestPrefixManager_ClearAllData(t *testing.T) {
 		})
 	}
 }
+
+func TestPrefixManager_ReplaceData(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+	staged, err := NewPrefixManager(filepath.Join(t.TempDir(), "staged.db"))
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer staged.Close()
+
+	if err := manager.AddPrefix(PrefixInfo{Prefix: "192.168.4.0/24", Platform: "AWS"}); err != nil {
+		t.Fatalf("Failed to add prefix: %v", err)
+	}
+	if err := staged.AddPrefix(PrefixInfo{Prefix: "192.168.6.0/24", Platform: "GCP", Region: stringPointer("global")}); err != nil {
+		t.Fatalf("Failed to add prefix: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := manager.ReplaceDataContext(ctx, staged); err == nil {
+		t.Errorf("PrefixManager.ReplaceDataContext() expected error for cancelled context")
+	}
+	if found, _, _ := manager.ContainsIP("192.168.4.10"); !found {
+		t.Errorf("Expected cancelled replace to keep existing prefixes")
+	}
+
+	if err := manager.ReplaceData(staged); err != nil {
+		t.Fatalf("PrefixManager.ReplaceData() error = %v", err)
+	}
+	if found, _, _ := manager.ContainsIP("192.168.4.10"); found {
+		t.Errorf("Expected replaced prefixes to be removed")
+	}
+	_, got, err := manager.ContainsIP("192.168.6.10")
+	if err != nil {
+		t.Fatalf("Failed to check IP: %v", err)
+	}
+	want := []PrefixInfo{{Prefix: "192.168.6.0/24", Platform: "GCP", Region: stringPointer("global")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixManager.ReplaceData() stored %v, want %v", got, want)
+	}
+}
+
+func TestPrefixManager_ContextCancelled(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	infos := []PrefixInfo{{Prefix: "192.168.5.0/24", Platform: "AWS"}}
+	if err := manager.AddPrefixBatchContext(ctx, infos); err == nil {
+		t.Errorf("PrefixManager.AddPrefixBatchContext() expected error for cancelled context")
+	}
+	if _, _, err := manager.ContainsIPContext(ctx, "192.168.5.1"); err == nil {
+		t.Errorf("PrefixManager.ContainsIPContext() expected error for cancelled context")
+	}
+
+	found, _, err := manager.ContainsIP("192.168.5.1")
+	if err != nil {
+		t.Fatalf("Failed to check IP: %v", err)
+	}
+	if found {
+		t.Errorf("Expected cancelled batch not to be inserted")
+	}
+}
+
+func TestPrefixManager_ListPrefixes(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.AddPrefixBatch([]PrefixInfo{
+		{Prefix: "192.168.6.0/24", Platform: "AWS", Region: stringPointer("us-east-1"), Service: stringPointer("EC2"), Metadata: Metadata{"network_border_group": "us-east-1"}},
+		{Prefix: "192.168.7.0/24", Platform: "AWS", Region: stringPointer("us-west-2"), Service: stringPointer("S3"), Metadata: Metadata{"network_border_group": "us-west-2-lax-1"}},
+		{Prefix: "2001:db8::/32", Platform: "Azure", Region: stringPointer("global"), Service: stringPointer("VM"), Metadata: Metadata{"location": map[string]any{"country_code": "US"}, "change_number": 7, "preview": true}},
+		{Prefix: "66.249.64.0/27", Platform: "Google", Service: stringPointer("Googlebot"), Category: stringPointer("bot")},
+		{Prefix: "192.168.0.0/16", Platform: "RIR", Service: stringPointer("arin"), Region: stringPointer("US"), Category: stringPointer(RegistryCategory)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add prefixes: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"No filter", Filter{}, 5},
+		{"Defaults exclude registry and bots", Filter{}.WithDefaults(), 3},
+		{"Defaults with platform", Filter{Platforms: []string{"RIR"}}.WithDefaults(), 1},
+		{"Defaults with service", Filter{Services: []string{"Googlebot"}}.WithDefaults(), 1},
+		{"Defaults with region", Filter{Regions: []string{"US"}}.WithDefaults(), 0},
+		{"Defaults with registry category", Filter{Categories: []string{RegistryCategory}}.WithDefaults(), 1},
+		{"Defaults replaced by exclusions", Filter{ExcludeCategories: []string{"bot"}}.WithDefaults(), 4},
+		{"Exclude categories", Filter{ExcludeCategories: []string{"bot", RegistryCategory}}, 3},
+		{"Platform", Filter{Platforms: []string{"AWS"}}, 2},
+		{"Multiple platforms", Filter{Platforms: []string{"AWS", "Azure"}}, 3},
+		{"Platform and service", Filter{Platforms: []string{"AWS"}, Services: []string{"S3"}}, 1},
+		{"Region", Filter{Regions: []string{"global"}}, 1},
+		{"No match", Filter{Platforms: []string{"GCP"}}, 0},
+		{"Metadata", Filter{Metadata: map[string][]string{"network_border_group": {"us-east-1"}}}, 1},
+		{"Metadata values", Filter{Metadata: map[string][]string{"network_border_group": {"us-east-1", "us-west-2-lax-1"}}}, 2},
+		{"Nested metadata", Filter{Metadata: map[string][]string{"location.country_code": {"US"}}}, 1},
+		{"Numeric metadata", Filter{Metadata: map[string][]string{"change_number": {"7"}}}, 1},
+		{"Boolean metadata", Filter{Metadata: map[string][]string{"preview": {"true"}}}, 1},
+		{"Boolean metadata false", Filter{Metadata: map[string][]string{"preview": {"false"}}}, 0},
+		{"Boolean metadata as number", Filter{Metadata: map[string][]string{"preview": {"1"}}}, 0},
+		{"Metadata and platform", Filter{Platforms: []string{"Azure"}, Metadata: map[string][]string{"network_border_group": {"us-east-1"}}}, 0},
+		{"Missing metadata key", Filter{Metadata: map[string][]string{"missing": {"us-east-1"}}}, 0},
+		{"Category", Filter{Categories: []string{"bot"}}, 1},
+		{"Category and platform", Filter{Platforms: []string{"AWS"}, Categories: []string{"bot"}}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := manager.ListPrefixes(tt.filter)
+			if err != nil {
+				t.Fatalf("PrefixManager.ListPrefixes() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("PrefixManager.ListPrefixes() len = %d, want %d", len(got), tt.want)
+			}
+		})
+	}
+
+	_, got, err := manager.ContainsIP("66.249.64.1")
+	if err != nil {
+		t.Fatalf("PrefixManager.ContainsIP() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Category == nil || *got[0].Category != "bot" {
+		t.Errorf("PrefixManager.ContainsIP() = %+v, want category bot", got)
+	}
+}
+
+func TestPrefixManager_Sources(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	updated := time.Date(2024, 10, 8, 23, 13, 6, 0, time.UTC)
+	sources := []Source{
+		{URL: "https://ip-ranges.amazonaws.com/ip-ranges.json", Platform: "AWS", Prefixes: 1, Published: stringPointer("2024-10-08-23-13-06"), UpdatedAt: updated.Add(-time.Hour)},
+		{URL: "https://ip-ranges.amazonaws.com/ip-ranges.json", Platform: "AWS", Prefixes: 2, Published: stringPointer("2024-10-08-23-13-06"), SyncToken: stringPointer("1728429186"), UpdatedAt: updated},
+		{URL: "https://api.github.com/meta", Platform: "GitHub", Prefixes: 3, UpdatedAt: updated},
+	}
+	for _, s := range sources {
+		if err := manager.SetSource(s); err != nil {
+			t.Fatalf("PrefixManager.SetSource() error = %v", err)
+		}
+	}
+
+	got, err := manager.ListSources()
+	if err != nil {
+		t.Fatalf("PrefixManager.ListSources() error = %v", err)
+	}
+	if want := sources[1:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixManager.ListSources() = %+v, want %+v", got, want)
+	}
+
+	if err := manager.ClearAllData(); err != nil {
+		t.Fatalf("PrefixManager.ClearAllData() error = %v", err)
+	}
+	got, err = manager.ListSources()
+	if err != nil {
+		t.Fatalf("PrefixManager.ListSources() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Expected no sources after clearing data, but found %d", len(got))
+	}
+}
+
+func TestPrefixManager_AddPrefixReplaces(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	err = manager.AddPrefixBatch([]PrefixInfo{
+		{Prefix: "192.168.8.0/24", Platform: "AWS", Service: stringPointer("EC2"), Metadata: Metadata{"a": 1}},
+		{Prefix: "192.168.8.0/24", Platform: "AWS", Service: stringPointer("EC2"), Metadata: Metadata{"a": 2}},
+		{Prefix: "192.168.8.0/24", Platform: "AWS", Service: stringPointer("S3")},
+		{Prefix: "192.168.8.0/24", Platform: "GitHub"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to add prefixes: %v", err)
+	}
+	if err := manager.AddPrefix(PrefixInfo{Prefix: "192.168.8.0/24", Platform: "GitHub"}); err != nil {
+		t.Fatalf("Failed to add prefix: %v", err)
+	}
+
+	infos, err := manager.ListPrefixes(Filter{})
+	if err != nil {
+		t.Fatalf("PrefixManager.ListPrefixes() error = %v", err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("PrefixManager.ListPrefixes() returned %d prefixes, want 3: %+v", len(infos), infos)
+	}
+	for _, info := range infos {
+		if info.Service != nil && *info.Service == "EC2" && info.Metadata["a"] != 2.0 {
+			t.Errorf("Expected the last EC2 entry to replace the first, got metadata %v", info.Metadata)
+		}
+	}
+}
+
+// loadAwsPrefixes reads the full AWS ip-ranges.json used by the update tests.
+func loadAwsPrefixes(b *testing.B) []PrefixInfo {
+	body, err := os.ReadFile(filepath.Join("..", "update", "testdata", "aws_response.json"))
+	if err != nil {
+		b.Fatalf("Failed to read AWS prefixes: %v", err)
+	}
+	var j struct {
+		Prefixes []struct {
+			IPPrefix string  `json:"ip_prefix"`
+			Region   *string `json:"region"`
+			Service  *string `json:"service"`
+		} `json:"prefixes"`
+		Ipv6Prefixes []struct {
+			Ipv6Prefix string  `json:"ipv6_prefix"`
+			Region     *string `json:"region"`
+			Service    *string `json:"service"`
+		} `json:"ipv6_prefixes"`
+	}
+	if err := json.Unmarshal(body, &j); err != nil {
+		b.Fatalf("Failed to parse AWS prefixes: %v", err)
+	}
+
+	var infos []PrefixInfo
+	for _, p := range j.Prefixes {
+		infos = append(infos, PrefixInfo{Prefix: p.IPPrefix, Platform: "AWS", Region: p.Region, Service: p.Service})
+	}
+	for _, p := range j.Ipv6Prefixes {
+		infos = append(infos, PrefixInfo{Prefix: p.Ipv6Prefix, Platform: "AWS", Region: p.Region, Service: p.Service})
+	}
+	return infos
+}
+
+func BenchmarkPrefixManager_ContainsIP(b *testing.B) {
+	manager, err := NewPrefixManager(filepath.Join(b.TempDir(), "cloudprefixes.db"))
+	if err != nil {
+		b.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	infos := loadAwsPrefixes(b)
+	if err := manager.AddPrefixBatch(infos); err != nil {
+		b.Fatalf("Failed to add prefixes: %v", err)
+	}
+	b.Logf("loaded %d AWS prefixes", len(infos))
+
+	for _, bm := range []struct {
+		name string
+		ip   string
+	}{
+		{"IPv4 hit", "3.5.140.1"},
+		{"IPv4 miss", "203.0.113.5"},
+		{"IPv6 hit", "2600:1f18:6fe3:8c00::1"},
+		{"IPv6 miss", "2001:db8::1"},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := manager.ContainsIP(bm.ip); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMetadata_RoundTrip(t *testing.T) {
+	manager, err := NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create IPRangeManager: %v", err)
+	}
+	defer manager.Close()
+
+	want := []PrefixInfo{
+		{Prefix: "192.168.9.0/24", Platform: "AWS", Metadata: Metadata{"network_border_group": "us-east-1"}},
+		{Prefix: "192.168.10.0/24", Platform: "Digital Ocean", Metadata: Metadata{"location": map[string]any{"country_code": "US", "city": "New York"}}},
+		{Prefix: "192.168.11.0/24", Platform: "GitHub"},
+	}
+	if err := manager.AddPrefixBatch(want); err != nil {
+		t.Fatalf("Failed to add prefixes: %v", err)
+	}
+
+	got, err := manager.ListPrefixes(Filter{})
+	if err != nil {
+		t.Fatalf("PrefixManager.ListPrefixes() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixManager.ListPrefixes() = %+v, want %+v", got, want)
+	}
+
+	b, err := json.Marshal(got[0])
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if wantJSON := `{"prefix":"192.168.9.0/24","platform":"AWS","metadata":{"network_border_group":"us-east-1"}}`; string(b) != wantJSON {
+		t.Errorf("json.Marshal() = %s, want %s", b, wantJSON)
+	}
+}
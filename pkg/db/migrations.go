@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration upgrades the schema by one version. Migrations are applied in
+// order, each in its own transaction, so the schema version of a database is
+// the number of migrations applied to it.
+type migration struct {
+	description string
+	up          func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations must only ever be appended to. Changing an applied migration
+// won't affect existing databases.
+var migrations = []migration{
+	{
+		// databases created before versioning have this table already
+		description: "create cloud_prefixes",
+		up: execMigration(`
+            CREATE TABLE IF NOT EXISTS cloud_prefixes (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                service TEXT,
+                platform TEXT,
+                region TEXT,
+                prefix TEXT,
+                start_ip_high INTEGER,
+                start_ip_low INTEGER,
+                end_ip_high INTEGER,
+                end_ip_low INTEGER,
+                ip_version INTEGER,
+                metadata JSONB
+            )`),
+	},
+	{
+		description: "create sources",
+		up: execMigration(`
+            CREATE TABLE IF NOT EXISTS sources (
+                url TEXT PRIMARY KEY,
+                platform TEXT,
+                prefixes INTEGER,
+                published TEXT,
+                updated_at TEXT
+            )`),
+	},
+	{
+		description: "index and deduplicate cloud_prefixes",
+		up:          indexPrefixes,
+	},
+	{
+		// earlier versions misspelt the key in AWS metadata
+		description: "rename network_boarder_group metadata",
+		up: execMigration(`
+            UPDATE cloud_prefixes
+            SET metadata = json_set(
+                json_remove(metadata, '$.network_boarder_group'),
+                '$.network_border_group', json_extract(metadata, '$.network_boarder_group')
+            )
+            WHERE json_type(metadata, '$.network_boarder_group') IS NOT NULL`),
+	},
+	{
+		description: "add sources sync_token",
+		up:          execMigration("ALTER TABLE sources ADD COLUMN sync_token TEXT"),
+	},
+	{
+		description: "create domains",
+		up: execMigration(`
+            CREATE TABLE IF NOT EXISTS domains (
+                id INTEGER PRIMARY KEY AUTOINCREMENT,
+                domain TEXT NOT NULL,
+                platform TEXT,
+                service TEXT,
+                metadata JSONB
+            )`,
+			`CREATE UNIQUE INDEX IF NOT EXISTS domains_unique
+         ON domains (domain, platform, IFNULL(service, ''))`,
+		),
+	},
+	{
+		// domains listed by several instances of a platform, such as the
+		// Microsoft 365 clouds, are kept apart by region
+		description: "add domains region",
+		up: execMigration(
+			"ALTER TABLE domains ADD COLUMN region TEXT",
+			"DROP INDEX IF EXISTS domains_unique",
+			`CREATE UNIQUE INDEX domains_unique
+         ON domains (domain, platform, IFNULL(service, ''), IFNULL(region, ''))`,
+		),
+	},
+	{
+		description: "add cloud_prefixes category",
+		up:          execMigration("ALTER TABLE cloud_prefixes ADD COLUMN category TEXT"),
+	},
+	{
+		description: "create asn_imports",
+		up: execMigration(`
+            CREATE TABLE IF NOT EXISTS asn_imports (
+                path TEXT,
+                platform TEXT,
+                asns TEXT,
+                PRIMARY KEY (path, platform)
+            )`),
+	},
+}
+
+func execMigration(statements ...string) func(context.Context, *sql.Tx) error {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		for _, s := range statements {
+			if _, err := tx.ExecContext(ctx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SchemaVersion returns the schema version of the open database.
+func (m *PrefixManager) SchemaVersion() (int, error) {
+	return schemaVersion(context.Background(), m.db)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func schemaVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, "SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return version, err
+}
+
+// migrate applies every migration newer than the database's schema version.
+// It refuses to open a database created by a newer version, whose schema
+// this version can't know how to use.
+func (m *PrefixManager) migrate(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(ctx, m.db)
+	if err != nil {
+		return fmt.Errorf("error reading schema version: %v", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d, upgrade cloudprefixes to use it", version, len(migrations))
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := m.applyMigration(ctx, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("error migrating database to version %d (%s): %v", i+1, migrations[i].description, err)
+		}
+	}
+	return nil
+}
+
+func (m *PrefixManager) applyMigration(ctx context.Context, version int, mig migration) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// another process may have migrated the database since it was checked
+	current, err := schemaVersion(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if current >= version {
+		return nil
+	}
+
+	if err := mig.up(ctx, tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_version"); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_version (version) VALUES (?)", version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// indexPrefixes removes duplicate entries, keeping the most recently added,
+// so each prefix, platform, service and region can be made unique. It also
+// recomputes the address columns, which earlier versions stored incorrectly
+// for IPv6, and indexes them for lookups.
+func indexPrefixes(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+        DELETE FROM cloud_prefixes WHERE id NOT IN (
+            SELECT MAX(id) FROM cloud_prefixes
+            GROUP BY platform, IFNULL(service, ''), IFNULL(region, ''), prefix
+        )`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, prefix FROM cloud_prefixes")
+	if err != nil {
+		return err
+	}
+	ranges := map[int64]ipRange{}
+	for rows.Next() {
+		var id int64
+		var prefix string
+		if err := rows.Scan(&id, &prefix); err != nil {
+			rows.Close()
+			return err
+		}
+		r, err := parseRange(prefix)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("invalid CIDR %s: %v", prefix, err)
+		}
+		ranges[id] = r
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+        UPDATE cloud_prefixes
+        SET start_ip_high = ?, start_ip_low = ?, end_ip_high = ?, end_ip_low = ?, ip_version = ?
+        WHERE id = ?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for id, r := range ranges {
+		if _, err := stmt.ExecContext(ctx, r.startHigh, r.startLow, r.endHigh, r.endLow, r.version, id); err != nil {
+			return err
+		}
+	}
+
+	return execMigration(
+		`CREATE UNIQUE INDEX IF NOT EXISTS cloud_prefixes_unique
+         ON cloud_prefixes (platform, IFNULL(service, ''), IFNULL(region, ''), prefix)`,
+		`CREATE INDEX IF NOT EXISTS cloud_prefixes_start
+         ON cloud_prefixes (ip_version, start_ip_high, start_ip_low)`,
+	)(ctx, tx)
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestPrefixManager_migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudprefixes.db")
+
+	manager, err := NewPrefixManager(path)
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	if err := manager.AddPrefix(PrefixInfo{Prefix: "192.168.1.0/24", Platform: "AWS"}); err != nil {
+		t.Fatalf("Failed to add prefix: %v", err)
+	}
+	version, err := manager.SchemaVersion()
+	if err != nil {
+		t.Fatalf("PrefixManager.SchemaVersion() error = %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("PrefixManager.SchemaVersion() = %d, want %d", version, len(migrations))
+	}
+	manager.Close()
+
+	// reopening an up to date database changes nothing
+	manager, err = NewPrefixManager(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen PrefixManager: %v", err)
+	}
+	found, _, err := manager.ContainsIP("192.168.1.1")
+	if err != nil || !found {
+		t.Errorf("PrefixManager.ContainsIP() = %v, %v after reopening, want true", found, err)
+	}
+	manager.Close()
+}
+
+func TestPrefixManager_migrateUnversioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudprefixes.db")
+
+	// the schema created before databases were versioned
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = conn.Exec(`
+        CREATE TABLE cloud_prefixes (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+			service TEXT,
+			platform TEXT,
+			region TEXT,
+            prefix TEXT,
+			start_ip_high INTEGER,
+            start_ip_low INTEGER,
+            end_ip_high INTEGER,
+            end_ip_low INTEGER,
+			ip_version INTEGER,
+			metadata JSONB
+        );
+        INSERT INTO cloud_prefixes (prefix, start_ip_high, start_ip_low, end_ip_high, end_ip_low, ip_version, platform)
+        VALUES ('192.168.1.0/24', 0, 3232235776, 0, 3232236031, 4, 'AWS'),
+        ('192.168.1.0/24', 0, 3232235776, 0, 3232236031, 4, 'AWS'),
+        ('2001:db8::/32', 2306139568115548160, 2306139568115548160, 2306139572410515455, 2306139572410515455, 6, 'Azure');
+        UPDATE cloud_prefixes SET metadata = '{"network_boarder_group":"us-east-1"}' WHERE platform = 'AWS';
+    `)
+	if err != nil {
+		t.Fatalf("Failed to create unversioned database: %v", err)
+	}
+	conn.Close()
+
+	manager, err := NewPrefixManager(path)
+	if err != nil {
+		t.Fatalf("Failed to open unversioned database: %v", err)
+	}
+	defer manager.Close()
+
+	version, err := manager.SchemaVersion()
+	if err != nil {
+		t.Fatalf("PrefixManager.SchemaVersion() error = %v", err)
+	}
+	if version != len(migrations) {
+		t.Errorf("PrefixManager.SchemaVersion() = %d, want %d", version, len(migrations))
+	}
+	infos, err := manager.ListPrefixes(Filter{})
+	if err != nil {
+		t.Fatalf("PrefixManager.ListPrefixes() error = %v", err)
+	}
+	if len(infos) != 2 || infos[0].Prefix != "192.168.1.0/24" || infos[1].Prefix != "2001:db8::/32" {
+		t.Errorf("PrefixManager.ListPrefixes() = %+v, want the existing prefixes without duplicates", infos)
+	}
+
+	if want := (Metadata{"network_border_group": "us-east-1"}); !reflect.DeepEqual(infos[0].Metadata, want) {
+		t.Errorf("PrefixManager.ListPrefixes() metadata = %v, want %v", infos[0].Metadata, want)
+	}
+
+	// the IPv6 addresses were stored incorrectly before they were indexed
+	found, _, err := manager.ContainsIP("2001:db8:ffff::1")
+	if err != nil || !found {
+		t.Errorf("PrefixManager.ContainsIP() = %v, %v after migrating, want true", found, err)
+	}
+}
+
+func TestPrefixManager_migrateNewer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cloudprefixes.db")
+
+	manager, err := NewPrefixManager(path)
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	if _, err := manager.db.Exec("UPDATE schema_version SET version = ?", len(migrations)+1); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	manager.Close()
+
+	_, err = NewPrefixManager(path)
+	if err == nil || !strings.Contains(err.Error(), "newer than the supported version") {
+		t.Errorf("NewPrefixManager() error = %v, want newer schema error", err)
+	}
+}
@@ -0,0 +1,32 @@
+package export
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// aggregate returns the minimal list of CIDRs covering the prefixes in infos,
+// split by address family, so generated sets and allowlists stay small.
+func aggregate(infos []db.PrefixInfo) (v4 []netip.Prefix, v6 []netip.Prefix, err error) {
+	prefixes, err := parsePrefixes(infos)
+	if err != nil {
+		return nil, nil, err
+	}
+	v4, v6 = cidr.SplitFamilies(cidr.Aggregate(prefixes))
+	return v4, v6, nil
+}
+
+func parsePrefixes(infos []db.PrefixInfo) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(infos))
+	for _, info := range infos {
+		p, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,69 @@
+// Package export writes prefixes from the database in formats consumed by
+// other tools.
+package export
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Options control how an exporter names and describes its output.
+type Options struct {
+	// Name identifies the generated object, e.g. the database type of an
+	// MMDB file. Exporters fall back to a default when it is empty.
+	Name string
+}
+
+// Exporter writes infos to w in a specific format.
+type Exporter func(w io.Writer, infos []db.PrefixInfo, opts Options) error
+
+var exporters = map[string]Exporter{
+	"mmdb":      ExportMMDB,
+	"nftables":  ExportNftables,
+	"ipset":     ExportIpset,
+	"iptables":  ExportIptables,
+	"ip6tables": ExportIp6tables,
+	"pf":        ExportPf,
+
+	"nginx":        ExportNginx,
+	"nginx-deny":   ExportNginxDeny,
+	"nginx-realip": ExportNginxRealIP,
+	"apache":       ExportApache,
+	"apache-deny":  ExportApacheDeny,
+	"caddy":        ExportCaddy,
+	"haproxy":      ExportHAProxy,
+
+	"splunk":     ExportSplunk,
+	"elastic":    ExportElastic,
+	"zeek-intel": ExportZeekIntel,
+	"zeek-table": ExportZeekTable,
+}
+
+// Formats returns the names of the supported formats in sorted order.
+func Formats() []string {
+	formats := make([]string, 0, len(exporters))
+	for f := range exporters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Export writes infos to w in the named format.
+func Export(w io.Writer, format string, infos []db.PrefixInfo, opts Options) error {
+	exporter, ok := exporters[format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	return exporter(w, infos, opts)
+}
+
+func nameOrDefault(opts Options, def string) string {
+	if opts.Name != "" {
+		return opts.Name
+	}
+	return def
+}
@@ -0,0 +1,154 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+const generatedBy = "Generated by cloudprefixes"
+
+// ExportNftables writes an nft script defining an IPv4 and an IPv6 interval
+// set in the inet table cloudprefixes. Loading it with `nft -f` replaces the
+// set contents in a single transaction.
+func ExportNftables(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	v4, v6, err := aggregate(infos)
+	if err != nil {
+		return err
+	}
+	if len(v4)+len(v6) == 0 {
+		return fmt.Errorf("no prefixes to export, loading empty nftables sets would flush the live ones")
+	}
+	name := nameOrDefault(opts, "cloudprefixes")
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#!/usr/sbin/nft -f\n# %s\n\n", generatedBy)
+	fmt.Fprintln(bw, "add table inet cloudprefixes")
+	for _, set := range []struct {
+		name     string
+		addrType string
+		prefixes []netip.Prefix
+	}{
+		{name + "_v4", "ipv4_addr", v4},
+		{name + "_v6", "ipv6_addr", v6},
+	} {
+		fmt.Fprintf(bw, "\nadd set inet cloudprefixes %s { type %s; flags interval; }\n", set.name, set.addrType)
+		fmt.Fprintf(bw, "flush set inet cloudprefixes %s\n", set.name)
+		// nft rejects an empty element list
+		if len(set.prefixes) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "add element inet cloudprefixes %s {\n", set.name)
+		for i, p := range set.prefixes {
+			sep := ","
+			if i == len(set.prefixes)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(bw, "\t%s%s\n", p, sep)
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+// ExportIpset writes an `ipset restore` script for an IPv4 and an IPv6
+// hash:net set. Each set is filled under a temporary name and swapped into
+// place so the live set is never partially populated.
+func ExportIpset(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	v4, v6, err := aggregate(infos)
+	if err != nil {
+		return err
+	}
+	if len(v4)+len(v6) == 0 {
+		return fmt.Errorf("no prefixes to export, restoring empty ipsets would empty the live ones")
+	}
+	name := nameOrDefault(opts, "cloudprefixes")
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", generatedBy)
+	for _, set := range []struct {
+		name     string
+		family   string
+		prefixes []netip.Prefix
+	}{
+		{name + "-v4", "inet", v4},
+		{name + "-v6", "inet6", v6},
+	} {
+		maxElem := 65536
+		if len(set.prefixes) > maxElem {
+			maxElem = len(set.prefixes)
+		}
+		tmp := set.name + "-tmp"
+		fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", set.name, set.family, maxElem)
+		fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", tmp, set.family, maxElem)
+		fmt.Fprintf(bw, "flush %s\n", tmp)
+		for _, p := range set.prefixes {
+			fmt.Fprintf(bw, "add %s %s\n", tmp, p)
+		}
+		fmt.Fprintf(bw, "swap %s %s\n", tmp, set.name)
+		fmt.Fprintf(bw, "destroy %s\n", tmp)
+	}
+	return bw.Flush()
+}
+
+// ExportIptables writes an `iptables-restore --noflush` file defining a chain
+// that accepts traffic to the IPv4 prefixes. Jump to the chain from OUTPUT or
+// FORWARD to use it.
+func ExportIptables(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	v4, _, err := aggregate(infos)
+	if err != nil {
+		return err
+	}
+	if len(v4) == 0 {
+		return fmt.Errorf("no IPv4 prefixes to export, restoring an empty chain would flush the live one")
+	}
+	return writeIptables(w, nameOrDefault(opts, "cloudprefixes"), v4)
+}
+
+// ExportIp6tables is ExportIptables for the IPv6 prefixes, to be loaded with
+// `ip6tables-restore --noflush`.
+func ExportIp6tables(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	_, v6, err := aggregate(infos)
+	if err != nil {
+		return err
+	}
+	if len(v6) == 0 {
+		return fmt.Errorf("no IPv6 prefixes to export, restoring an empty chain would flush the live one")
+	}
+	return writeIptables(w, nameOrDefault(opts, "cloudprefixes"), v6)
+}
+
+func writeIptables(w io.Writer, chain string, prefixes []netip.Prefix) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", generatedBy)
+	fmt.Fprintln(bw, "*filter")
+	// declaring the chain flushes it, the restore is applied on COMMIT
+	fmt.Fprintf(bw, ":%s - [0:0]\n", chain)
+	for _, p := range prefixes {
+		fmt.Fprintf(bw, "-A %s -d %s -j ACCEPT\n", chain, p)
+	}
+	fmt.Fprintln(bw, "COMMIT")
+	return bw.Flush()
+}
+
+// ExportPf writes a pf table file holding both address families, loaded
+// atomically with `pfctl -t NAME -T replace -f FILE`.
+func ExportPf(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	v4, v6, err := aggregate(infos)
+	if err != nil {
+		return err
+	}
+	if len(v4)+len(v6) == 0 {
+		return fmt.Errorf("no prefixes to export, replacing a pf table with an empty file would empty it")
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s for table <%s>\n", generatedBy, nameOrDefault(opts, "cloudprefixes"))
+	for _, p := range append(v4, v6...) {
+		fmt.Fprintln(bw, p)
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestFirewallExporters(t *testing.T) {
+	tests := []struct {
+		format   string
+		opts     Options
+		contains []string
+		excludes []string
+	}{
+		{
+			"nftables",
+			Options{Name: "github"},
+			[]string{
+				"add set inet cloudprefixes github_v4 { type ipv4_addr; flags interval; }",
+				"flush set inet cloudprefixes github_v6",
+				"\t192.30.252.0/22\n",
+				"\t2600:1f13::/36\n",
+			},
+			[]string{"2600:1f13:a0d:a700::/56"},
+		},
+		{
+			"ipset",
+			Options{},
+			[]string{
+				"create cloudprefixes-v4 hash:net family inet",
+				"add cloudprefixes-v4-tmp 45.55.32.0/19\n",
+				"swap cloudprefixes-v6-tmp cloudprefixes-v6\n",
+			},
+			nil,
+		},
+		{
+			"iptables",
+			Options{Name: "CLOUD"},
+			[]string{":CLOUD - [0:0]\n", "-A CLOUD -d 192.30.252.0/22 -j ACCEPT\n", "COMMIT\n"},
+			[]string{"2600:1f13::/36"},
+		},
+		{
+			"ip6tables",
+			Options{Name: "CLOUD"},
+			[]string{"-A CLOUD -d 2600:1f13::/36 -j ACCEPT\n"},
+			[]string{"192.30.252.0/22"},
+		},
+		{
+			"pf",
+			Options{},
+			[]string{"192.30.252.0/22\n", "2600:1f13::/36\n"},
+			[]string{"2600:1f13:a0d:a700::/56"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, tt.format, testPrefixes, tt.opts); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			out := buf.String()
+			for _, s := range tt.contains {
+				if !strings.Contains(out, s) {
+					t.Errorf("Export() output missing %q:\n%s", s, out)
+				}
+			}
+			for _, s := range tt.excludes {
+				if strings.Contains(out, s) {
+					t.Errorf("Export() output contains %q:\n%s", s, out)
+				}
+			}
+			// aggregation leaves one entry for the duplicated GitHub prefix
+			if n := strings.Count(out, "192.30.252.0/22"); n > 1 {
+				t.Errorf("Export() output lists 192.30.252.0/22 %d times", n)
+			}
+		})
+	}
+}
+
+func TestFirewallExporters_Empty(t *testing.T) {
+	v4Only := []db.PrefixInfo{{Prefix: "192.30.252.0/22", Platform: "GitHub"}}
+	tests := []struct {
+		format string
+		infos  []db.PrefixInfo
+	}{
+		{"nftables", nil},
+		{"ipset", nil},
+		{"iptables", nil},
+		{"ip6tables", nil},
+		{"ip6tables", v4Only},
+		{"pf", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if err := Export(&bytes.Buffer{}, tt.format, tt.infos, Options{}); err == nil {
+				t.Errorf("Export() expected error for no prefixes")
+			}
+		})
+	}
+}
+
+func TestExportNftables_SingleFamily(t *testing.T) {
+	var buf bytes.Buffer
+	infos := []db.PrefixInfo{{Prefix: "192.30.252.0/22", Platform: "GitHub"}}
+	if err := ExportNftables(&buf, infos, Options{}); err != nil {
+		t.Fatalf("ExportNftables() error = %v", err)
+	}
+	if n := strings.Count(buf.String(), "add element"); n != 1 {
+		t.Errorf("ExportNftables() wrote %d element lists, want 1 as nft rejects an empty one:\n%s", n, buf.String())
+	}
+}
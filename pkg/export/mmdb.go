@@ -0,0 +1,147 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/mmdb"
+)
+
+// ExportMMDB writes infos as a MaxMind DB.
+//
+// Where prefixes overlap, each network takes the platform, region and
+// metadata of the most specific prefix containing it, while platforms and
+// services lists are merged from every prefix containing it. A network inside
+// both an AWS /36 tagged AMAZON and a /56 tagged EC2_INSTANCE_CONNECT
+// therefore reports the /56 and both services.
+func ExportMMDB(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	byPrefix := map[netip.Prefix][]db.PrefixInfo{}
+	for _, info := range infos {
+		prefix, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		prefix = prefix.Masked()
+		byPrefix[prefix] = append(byPrefix[prefix], info)
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(byPrefix))
+	for p := range byPrefix {
+		prefixes = append(prefixes, p)
+	}
+	// the writer lets later, more specific, inserts override earlier ones
+	sort.Slice(prefixes, func(i, j int) bool {
+		if prefixes[i].Bits() != prefixes[j].Bits() {
+			return prefixes[i].Bits() < prefixes[j].Bits()
+		}
+		return prefixes[i].Addr().Less(prefixes[j].Addr())
+	})
+
+	writer := mmdb.NewWriter(nameOrDefault(opts, "cloudprefixes"), "Cloud and hosting provider prefixes")
+	for _, p := range prefixes {
+		if err := writer.Insert(p, mmdbRecord(p, covering(byPrefix, p))); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.WriteTo(w)
+	return err
+}
+
+// covering returns the entries of every prefix in byPrefix that contains p,
+// ordered from most to least specific.
+func covering(byPrefix map[netip.Prefix][]db.PrefixInfo, p netip.Prefix) [][]db.PrefixInfo {
+	var result [][]db.PrefixInfo
+	for bits := p.Bits(); bits >= 0; bits-- {
+		parent, err := p.Addr().Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if infos, ok := byPrefix[parent]; ok {
+			result = append(result, infos)
+		}
+	}
+	return result
+}
+
+func mmdbRecord(p netip.Prefix, levels [][]db.PrefixInfo) map[string]any {
+	record := map[string]any{
+		"network":  p.String(),
+		"platform": levels[0][0].Platform,
+	}
+
+	platforms := newStringSet()
+	services := newStringSet()
+	metadata := map[string]any{}
+	// walk from least to most specific so more specific metadata overrides
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, info := range levels[i] {
+			platforms.add(&info.Platform)
+			services.add(info.Service)
+			if info.Region != nil && *info.Region != "" {
+				record["region"] = *info.Region
+			}
+			for k, v := range info.Metadata {
+				metadata[k] = v
+			}
+		}
+	}
+
+	record["platforms"] = platforms.values()
+	if s := services.values(); len(s) > 0 {
+		record["services"] = s
+	}
+	if m, ok := mmdbValue(metadata).(map[string]any); ok && len(m) > 0 {
+		record["metadata"] = m
+	}
+	return record
+}
+
+// mmdbValue converts decoded JSON into values the MMDB writer can encode,
+// dropping nulls which have no MMDB representation.
+func mmdbValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		m := map[string]any{}
+		for k, e := range v {
+			if e = mmdbValue(e); e != nil {
+				m[k] = e
+			}
+		}
+		return m
+	case []any:
+		a := []any{}
+		for _, e := range v {
+			if e = mmdbValue(e); e != nil {
+				a = append(a, e)
+			}
+		}
+		return a
+	}
+	return v
+}
+
+// stringSet collects distinct non-empty strings in insertion order.
+type stringSet struct {
+	seen  map[string]bool
+	order []string
+}
+
+func newStringSet() *stringSet {
+	return &stringSet{seen: map[string]bool{}}
+}
+
+func (s *stringSet) add(v *string) {
+	if v == nil || *v == "" || s.seen[*v] {
+		return
+	}
+	s.seen[*v] = true
+	s.order = append(s.order, *v)
+}
+
+func (s *stringSet) values() []string {
+	return s.order
+}
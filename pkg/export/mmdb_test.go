@@ -0,0 +1,109 @@
+package export
+
+import (
+	"bytes"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+var testPrefixes = []db.PrefixInfo{
+	{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Hooks")},
+	{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Web")},
+	{
+		Prefix:   "2600:1f13::/36",
+		Platform: "AWS",
+		Region:   stringPointer("us-west-2"),
+		Service:  stringPointer("AMAZON"),
+		Metadata: db.Metadata{"network_border_group": "us-west-2"},
+	},
+	{
+		Prefix:   "2600:1f13:a0d:a700::/56",
+		Platform: "AWS",
+		Region:   stringPointer("us-west-2"),
+		Service:  stringPointer("EC2_INSTANCE_CONNECT"),
+		Metadata: db.Metadata{"network_border_group": "us-west-2b", "extra": nil},
+	},
+	{Prefix: "45.55.32.0/19", Platform: "Digital Ocean", Metadata: db.Metadata{"location": map[string]any{"country_code": "US"}}},
+}
+
+func TestExportMMDB(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, "mmdb", testPrefixes, Options{}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("\xAB\xCD\xEFMaxMind.com")) {
+		t.Errorf("Export() output is missing MMDB metadata")
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("EC2_INSTANCE_CONNECT")) {
+		t.Errorf("Export() output is missing record data")
+	}
+}
+
+func TestExport_UnknownFormat(t *testing.T) {
+	if err := Export(&bytes.Buffer{}, "nope", testPrefixes, Options{}); err == nil {
+		t.Errorf("Export() expected error for unknown format")
+	}
+}
+
+func Test_mmdbRecord(t *testing.T) {
+	byPrefix := map[netip.Prefix][]db.PrefixInfo{}
+	for _, info := range testPrefixes {
+		p := netip.MustParsePrefix(info.Prefix).Masked()
+		byPrefix[p] = append(byPrefix[p], info)
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		want   map[string]any
+	}{
+		{
+			"Merged services",
+			"192.30.252.0/22",
+			map[string]any{
+				"network":   "192.30.252.0/22",
+				"platform":  "GitHub",
+				"platforms": []string{"GitHub"},
+				"services":  []string{"Hooks", "Web"},
+			},
+		},
+		{
+			"Most specific wins",
+			"2600:1f13:a0d:a700::/56",
+			map[string]any{
+				"network":   "2600:1f13:a0d:a700::/56",
+				"platform":  "AWS",
+				"platforms": []string{"AWS"},
+				"region":    "us-west-2",
+				"services":  []string{"AMAZON", "EC2_INSTANCE_CONNECT"},
+				"metadata":  map[string]any{"network_border_group": "us-west-2b"},
+			},
+		},
+		{
+			"No service",
+			"45.55.32.0/19",
+			map[string]any{
+				"network":   "45.55.32.0/19",
+				"platform":  "Digital Ocean",
+				"platforms": []string{"Digital Ocean"},
+				"metadata":  map[string]any{"location": map[string]any{"country_code": "US"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := netip.MustParsePrefix(tt.prefix)
+			got := mmdbRecord(p, covering(byPrefix, p))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mmdbRecord() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package export
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// SIEM lookup tables keep one row per prefix and service rather than
+// aggregating, since the point is attributing an address to its provider.
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// ExportSplunk writes a CSV lookup table for a Splunk lookup definition with
+// `match_type = CIDR(cidr)`.
+func ExportSplunk(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"cidr", "platform", "service", "region", "metadata"})
+	for _, info := range infos {
+		var metadata string
+		if info.Metadata != nil {
+			b, err := json.Marshal(info.Metadata)
+			if err != nil {
+				return fmt.Errorf("invalid metadata for %s: %v", info.Prefix, err)
+			}
+			metadata = string(b)
+		}
+		cw.Write([]string{
+			info.Prefix,
+			info.Platform,
+			valueOrEmpty(info.Service),
+			valueOrEmpty(info.Region),
+			metadata,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type elasticDocument struct {
+	IPRange  string      `json:"ip_range"`
+	Platform string      `json:"platform"`
+	Service  *string     `json:"service,omitempty"`
+	Region   *string     `json:"region,omitempty"`
+	Metadata db.Metadata `json:"metadata,omitempty"`
+}
+
+// ExportElastic writes an Elasticsearch bulk request (NDJSON) indexing one
+// document per prefix into the index NAME. The documents' ip_range field is
+// intended as the match_field of a range enrich policy. Document IDs are
+// derived from the content so repeating the import doesn't add duplicates.
+func ExportElastic(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	index := nameOrDefault(opts, "cloudprefixes")
+	enc := json.NewEncoder(w)
+	for _, info := range infos {
+		doc := elasticDocument{
+			IPRange:  info.Prefix,
+			Platform: info.Platform,
+			Service:  info.Service,
+			Region:   info.Region,
+			Metadata: info.Metadata,
+		}
+
+		id := sha1.Sum([]byte(strings.Join([]string{
+			info.Prefix, info.Platform, valueOrEmpty(info.Service), valueOrEmpty(info.Region),
+		}, "\x00")))
+		action := map[string]map[string]string{
+			"index": {"_index": index, "_id": hex.EncodeToString(id[:])},
+		}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zeekField replaces characters that would break Zeek's tab separated format
+// and marks empty values as unset.
+func zeekField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// ExportZeekIntel writes a Zeek Intel framework file with an Intel::SUBNET
+// indicator per prefix. Zeek keeps one meta record per indicator and source,
+// so every platform, service and region sharing a prefix is combined into the
+// description.
+func ExportZeekIntel(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	source := nameOrDefault(opts, "cloudprefixes")
+
+	var prefixes []string
+	descs := map[string][]string{}
+	for _, info := range infos {
+		desc := []string{info.Platform}
+		for _, s := range []*string{info.Service, info.Region} {
+			if s != nil && *s != "" {
+				desc = append(desc, *s)
+			}
+		}
+		if _, ok := descs[info.Prefix]; !ok {
+			prefixes = append(prefixes, info.Prefix)
+		}
+		descs[info.Prefix] = append(descs[info.Prefix], strings.Join(desc, " "))
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#fields\tindicator\tindicator_type\tmeta.source\tmeta.desc")
+	for _, p := range prefixes {
+		fmt.Fprintf(bw, "%s\tIntel::SUBNET\t%s\t%s\n", p, zeekField(source), zeekField(strings.Join(descs[p], "; ")))
+	}
+	return bw.Flush()
+}
+
+// ExportZeekTable writes a Zeek input framework file for Input::add_table,
+// indexed by a subnet field named prefix. Table indexes must be unique, so
+// the platforms, services and regions of a prefix are written as
+// set[string] fields.
+func ExportZeekTable(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	type row struct {
+		platforms, services, regions *stringSet
+	}
+	var prefixes []string
+	rows := map[string]row{}
+	for _, info := range infos {
+		r, ok := rows[info.Prefix]
+		if !ok {
+			r = row{newStringSet(), newStringSet(), newStringSet()}
+			rows[info.Prefix] = r
+			prefixes = append(prefixes, info.Prefix)
+		}
+		r.platforms.add(&info.Platform)
+		r.services.add(info.Service)
+		r.regions.add(info.Region)
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "#fields\tprefix\tplatforms\tservices\tregions")
+	for _, p := range prefixes {
+		r := rows[p]
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\n", p, zeekSet(r.platforms), zeekSet(r.services), zeekSet(r.regions))
+	}
+	return bw.Flush()
+}
+
+func zeekSet(s *stringSet) string {
+	if len(s.values()) == 0 {
+		return "(empty)"
+	}
+	values := make([]string, len(s.values()))
+	for i, v := range s.values() {
+		values[i] = strings.ReplaceAll(zeekField(v), ",", " ")
+	}
+	return strings.Join(values, ",")
+}
@@ -0,0 +1,117 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportSplunk(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, "splunk", testPrefixes, Options{}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to read CSV: %v", err)
+	}
+	if len(records) != len(testPrefixes)+1 {
+		t.Fatalf("Export() wrote %d rows, want %d", len(records), len(testPrefixes)+1)
+	}
+	want := []string{"2600:1f13::/36", "AWS", "AMAZON", "us-west-2", `{"network_border_group":"us-west-2"}`}
+	if !reflect.DeepEqual(records[3], want) {
+		t.Errorf("Export() row = %v, want %v", records[3], want)
+	}
+}
+
+func TestExportElastic(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Export(&buf, "elastic", testPrefixes, Options{Name: "cloud"}); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2*len(testPrefixes) {
+		t.Fatalf("Export() wrote %d lines, want %d", len(lines), 2*len(testPrefixes))
+	}
+
+	var action struct {
+		Index struct {
+			Index string `json:"_index"`
+			ID    string `json:"_id"`
+		} `json:"index"`
+	}
+	if err := json.Unmarshal([]byte(lines[4]), &action); err != nil {
+		t.Fatalf("invalid action line: %v", err)
+	}
+	if action.Index.Index != "cloud" || action.Index.ID == "" {
+		t.Errorf("unexpected action %s", lines[4])
+	}
+
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(lines[5]), &doc); err != nil {
+		t.Fatalf("invalid document line: %v", err)
+	}
+	want := map[string]any{
+		"ip_range": "2600:1f13::/36",
+		"platform": "AWS",
+		"service":  "AMAZON",
+		"region":   "us-west-2",
+		"metadata": map[string]any{"network_border_group": "us-west-2"},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("Export() document = %v, want %v", doc, want)
+	}
+}
+
+func TestExportZeek(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{
+			"zeek-intel",
+			[]string{
+				"#fields\tindicator\tindicator_type\tmeta.source\tmeta.desc",
+				"192.30.252.0/22\tIntel::SUBNET\tcloudprefixes\tGitHub Hooks; GitHub Web",
+				"2600:1f13::/36\tIntel::SUBNET\tcloudprefixes\tAWS AMAZON us-west-2",
+			},
+		},
+		{
+			"zeek-table",
+			[]string{
+				"#fields\tprefix\tplatforms\tservices\tregions",
+				"192.30.252.0/22\tGitHub\tHooks,Web\t(empty)",
+				"45.55.32.0/19\tDigital Ocean\t(empty)\t(empty)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, tt.format, testPrefixes, Options{}); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			lines := strings.Split(buf.String(), "\n")
+			for _, want := range tt.want {
+				found := false
+				for _, l := range lines {
+					if l == want {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("Export() output missing line %q:\n%s", want, buf.String())
+				}
+			}
+		})
+	}
+}
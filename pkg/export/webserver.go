@@ -0,0 +1,122 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// aggregateAll returns the aggregated prefixes of both families, IPv4 first.
+func aggregateAll(infos []db.PrefixInfo) ([]netip.Prefix, error) {
+	v4, v6, err := aggregate(infos)
+	if err != nil {
+		return nil, err
+	}
+	return append(v4, v6...), nil
+}
+
+// writeLines writes a comment header followed by one formatted line per
+// prefix.
+func writeLines(w io.Writer, infos []db.PrefixInfo, header, format string) error {
+	prefixes, err := aggregateAll(infos)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", header)
+	for _, p := range prefixes {
+		fmt.Fprintf(bw, format, p)
+	}
+	return bw.Flush()
+}
+
+// ExportNginx writes nginx allow directives for the prefixes followed by
+// `deny all`, for inclusion in a server or location block.
+func ExportNginx(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	prefixes, err := aggregateAll(infos)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", generatedBy)
+	for _, p := range prefixes {
+		fmt.Fprintf(bw, "allow %s;\n", p)
+	}
+	fmt.Fprintln(bw, "deny all;")
+	return bw.Flush()
+}
+
+// ExportNginxDeny writes nginx deny directives for the prefixes.
+func ExportNginxDeny(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	return writeLines(w, infos, generatedBy, "deny %s;\n")
+}
+
+// ExportNginxRealIP writes nginx set_real_ip_from directives so the client
+// address is taken from headers set by the listed proxies, e.g. Cloudflare.
+func ExportNginxRealIP(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	return writeLines(w, infos, generatedBy, "set_real_ip_from %s;\n")
+}
+
+// ExportApache writes a RequireAny block granting access to the prefixes.
+func ExportApache(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	if len(infos) == 0 {
+		return fmt.Errorf("no prefixes to export, apache rejects an empty RequireAny block")
+	}
+	return writeApache(w, infos, "RequireAny", "", "Require ip")
+}
+
+// ExportApacheDeny writes a RequireAll block refusing access to the prefixes.
+func ExportApacheDeny(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	return writeApache(w, infos, "RequireAll", "Require all granted", "Require not ip")
+}
+
+func writeApache(w io.Writer, infos []db.PrefixInfo, block, first, directive string) error {
+	prefixes, err := aggregateAll(infos)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", generatedBy)
+	fmt.Fprintf(bw, "<%s>\n", block)
+	if first != "" {
+		fmt.Fprintf(bw, "    %s\n", first)
+	}
+	for _, p := range prefixes {
+		fmt.Fprintf(bw, "    %s %s\n", directive, p)
+	}
+	fmt.Fprintf(bw, "</%s>\n", block)
+	return bw.Flush()
+}
+
+// ExportCaddy writes a Caddyfile named matcher, @NAME, matching requests
+// from the prefixes with remote_ip.
+func ExportCaddy(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	prefixes, err := aggregateAll(infos)
+	if err != nil {
+		return err
+	}
+	if len(prefixes) == 0 {
+		return fmt.Errorf("no prefixes to export, an empty caddy matcher would match every request")
+	}
+	ranges := make([]string, len(prefixes))
+	for i, p := range prefixes {
+		ranges[i] = p.String()
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n", generatedBy)
+	fmt.Fprintf(bw, "@%s {\n", nameOrDefault(opts, "cloudprefixes"))
+	fmt.Fprintf(bw, "\tremote_ip %s\n", strings.Join(ranges, " "))
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// ExportHAProxy writes an ACL file with one prefix per line, loaded with
+// `acl NAME src -f FILE`.
+func ExportHAProxy(w io.Writer, infos []db.PrefixInfo, opts Options) error {
+	return writeLines(w, infos, generatedBy, "%s\n")
+}
@@ -0,0 +1,81 @@
+package export
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestWebServerExporters(t *testing.T) {
+	tests := []struct {
+		format string
+		opts   Options
+		want   string
+	}{
+		{
+			"nginx",
+			Options{},
+			"allow 45.55.32.0/19;\nallow 192.30.252.0/22;\nallow 2600:1f13::/36;\ndeny all;\n",
+		},
+		{
+			"nginx-deny",
+			Options{},
+			"deny 45.55.32.0/19;\ndeny 192.30.252.0/22;\ndeny 2600:1f13::/36;\n",
+		},
+		{
+			"nginx-realip",
+			Options{},
+			"set_real_ip_from 45.55.32.0/19;\nset_real_ip_from 192.30.252.0/22;\nset_real_ip_from 2600:1f13::/36;\n",
+		},
+		{
+			"apache",
+			Options{},
+			"<RequireAny>\n    Require ip 45.55.32.0/19\n    Require ip 192.30.252.0/22\n    Require ip 2600:1f13::/36\n</RequireAny>\n",
+		},
+		{
+			"apache-deny",
+			Options{},
+			"<RequireAll>\n    Require all granted\n    Require not ip 45.55.32.0/19\n    Require not ip 192.30.252.0/22\n    Require not ip 2600:1f13::/36\n</RequireAll>\n",
+		},
+		{
+			"caddy",
+			Options{Name: "cloud"},
+			"@cloud {\n\tremote_ip 45.55.32.0/19 192.30.252.0/22 2600:1f13::/36\n}\n",
+		},
+		{
+			"haproxy",
+			Options{},
+			"45.55.32.0/19\n192.30.252.0/22\n2600:1f13::/36\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, tt.format, testPrefixes, tt.opts); err != nil {
+				t.Fatalf("Export() error = %v", err)
+			}
+			got := strings.TrimPrefix(buf.String(), "# "+generatedBy+"\n")
+			if got != tt.want {
+				t.Errorf("Export() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportCaddy_Empty(t *testing.T) {
+	if err := ExportCaddy(&bytes.Buffer{}, []db.PrefixInfo{}, Options{}); err == nil {
+		t.Errorf("ExportCaddy() expected error for an empty prefix list")
+	}
+}
+
+func TestExportApache_Empty(t *testing.T) {
+	if err := ExportApache(&bytes.Buffer{}, []db.PrefixInfo{}, Options{}); err == nil {
+		t.Errorf("ExportApache() expected error for an empty prefix list")
+	}
+	// a deny list with nothing to refuse is still a valid RequireAll block
+	if err := ExportApacheDeny(&bytes.Buffer{}, []db.PrefixInfo{}, Options{}); err != nil {
+		t.Errorf("ExportApacheDeny() error = %v", err)
+	}
+}
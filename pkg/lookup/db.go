@@ -0,0 +1,31 @@
+package lookup
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// DBLookuper answers lookups from a cloudprefixes SQLite database.
+type DBLookuper struct {
+	manager *db.PrefixManager
+}
+
+// NewDBLookuper returns a Lookuper backed by manager. The caller remains
+// responsible for closing manager.
+func NewDBLookuper(manager *db.PrefixManager) *DBLookuper {
+	return &DBLookuper{manager: manager}
+}
+
+func (l *DBLookuper) Lookup(ctx context.Context, addr netip.Addr, opts ...Option) ([]db.PrefixInfo, error) {
+	_, infos, err := l.manager.ContainsIPContext(ctx, addr.Unmap().String())
+	if err != nil {
+		return nil, err
+	}
+	return newFilter(opts).apply(infos), nil
+}
+
+func (l *DBLookuper) LookupBatch(ctx context.Context, addrs []netip.Addr, opts ...Option) ([]Result, error) {
+	return batch(ctx, l, addrs, opts...)
+}
@@ -0,0 +1,65 @@
+// Package lookup provides a stable API for attributing IP addresses to the
+// cloud and hosting prefixes collected by cloudprefixes.
+//
+// Lookups are made through the Lookuper interface, which is independent of
+// how the prefixes are stored. A Lookuper can be backed by the SQLite
+// database built by `cloudprefixes -update` (NewDBLookuper), by an in-memory
+// index (NewMemoryLookuper), or by any other implementation such as a client
+// for a remote lookup service.
+//
+//	manager, err := db.NewPrefixManager("cloudprefixes.db")
+//	if err != nil {
+//		return err
+//	}
+//	defer manager.Close()
+//
+//	l := lookup.NewDBLookuper(manager)
+//	prefixes, err := l.Lookup(ctx, netip.MustParseAddr("192.30.252.1"),
+//		lookup.WithPlatform("GitHub"))
+package lookup
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Lookuper finds the prefixes containing an IP address.
+type Lookuper interface {
+	// Lookup returns every prefix containing addr that matches opts. An
+	// address that is not contained in any prefix is not an error, the
+	// returned slice is empty.
+	Lookup(ctx context.Context, addr netip.Addr, opts ...Option) ([]db.PrefixInfo, error)
+
+	// LookupBatch looks up each address in addrs and returns one Result per
+	// address, in the same order as addrs.
+	LookupBatch(ctx context.Context, addrs []netip.Addr, opts ...Option) ([]Result, error)
+}
+
+// Result holds the prefixes found for a single address.
+type Result struct {
+	IP   netip.Addr      `json:"ip"`
+	Info []db.PrefixInfo `json:"info"`
+}
+
+// Found reports whether any prefix contained the address.
+func (r Result) Found() bool {
+	return len(r.Info) > 0
+}
+
+// batch implements LookupBatch for backends that only need to repeat Lookup.
+func batch(ctx context.Context, l Lookuper, addrs []netip.Addr, opts ...Option) ([]Result, error) {
+	results := make([]Result, 0, len(addrs))
+	for _, addr := range addrs {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+		info, err := l.Lookup(ctx, addr, opts...)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, Result{IP: addr, Info: info})
+	}
+	return results, nil
+}
@@ -0,0 +1,177 @@
+package lookup
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+var testPrefixes = []db.PrefixInfo{
+	{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Hooks")},
+	{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Web")},
+	{Prefix: "2600:1f13::/36", Platform: "AWS", Region: stringPointer("us-west-2"), Service: stringPointer("AMAZON")},
+	{Prefix: "2600:1f13:a0d:a700::/56", Platform: "AWS", Region: stringPointer("us-west-2"), Service: stringPointer("EC2_INSTANCE_CONNECT")},
+	{Prefix: "45.55.32.0/19", Platform: "Digital Ocean"},
+	{Prefix: "66.249.64.0/27", Platform: "Google", Service: stringPointer("Googlebot"), Category: stringPointer("bot")},
+	{Prefix: "66.249.64.0/19", Platform: "Google", Service: stringPointer("Google (non-Cloud)")},
+}
+
+func testLookupers(t *testing.T) map[string]Lookuper {
+	manager, err := db.NewPrefixManager(":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create PrefixManager: %v", err)
+	}
+	t.Cleanup(func() { manager.Close() })
+	if err := manager.AddPrefixBatch(testPrefixes); err != nil {
+		t.Fatalf("Failed to add prefixes: %v", err)
+	}
+
+	memory, err := NewMemoryLookuper(testPrefixes)
+	if err != nil {
+		t.Fatalf("Failed to create MemoryLookuper: %v", err)
+	}
+
+	return map[string]Lookuper{
+		"db":     NewDBLookuper(manager),
+		"memory": memory,
+	}
+}
+
+func TestLookuper_Lookup(t *testing.T) {
+	tests := []struct {
+		name string
+		ip   string
+		opts []Option
+		want int
+	}{
+		{"IPv4 match", "192.30.252.1", nil, 2},
+		{"IPv4 mapped IPv6", "::ffff:192.30.252.1", nil, 2},
+		{"IPv6 nested match", "2600:1f13:0a0d:a700::1", nil, 2},
+		{"No match", "203.0.113.5", nil, 0},
+		{"Platform filter", "192.30.252.1", []Option{WithPlatform("AWS")}, 0},
+		{"Service filter", "192.30.252.1", []Option{WithService("Hooks")}, 1},
+		{"Region filter", "2600:1f13:0a0d:a700::1", []Option{WithRegion("us-west-2")}, 2},
+		{"Region filter excludes nil", "45.55.32.1", []Option{WithRegion("us-west-2")}, 0},
+		{"Category filter", "66.249.64.1", []Option{WithCategory("bot")}, 1},
+		{"Category filter outside crawler", "66.249.65.1", []Option{WithCategory("bot")}, 0},
+		{"Category filter excludes nil", "192.30.252.1", []Option{WithCategory("bot")}, 0},
+	}
+	for backend, l := range testLookupers(t) {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				got, err := l.Lookup(context.Background(), netip.MustParseAddr(tt.ip), tt.opts...)
+				if err != nil {
+					t.Fatalf("Lookup() error = %v", err)
+				}
+				if len(got) != tt.want {
+					t.Errorf("Lookup() len = %d, want %d", len(got), tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestLookuper_LookupBatch(t *testing.T) {
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.30.252.1"),
+		netip.MustParseAddr("203.0.113.5"),
+		netip.MustParseAddr("45.55.32.1"),
+	}
+	for backend, l := range testLookupers(t) {
+		t.Run(backend, func(t *testing.T) {
+			results, err := l.LookupBatch(context.Background(), addrs)
+			if err != nil {
+				t.Fatalf("LookupBatch() error = %v", err)
+			}
+			if len(results) != len(addrs) {
+				t.Fatalf("LookupBatch() len = %d, want %d", len(results), len(addrs))
+			}
+			for i, want := range []bool{true, false, true} {
+				if results[i].IP != addrs[i] {
+					t.Errorf("result %d IP = %v, want %v", i, results[i].IP, addrs[i])
+				}
+				if results[i].Found() != want {
+					t.Errorf("result %d Found() = %v, want %v", i, results[i].Found(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestLookuper_LookupCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for backend, l := range testLookupers(t) {
+		t.Run(backend, func(t *testing.T) {
+			if _, err := l.Lookup(ctx, netip.MustParseAddr("192.30.252.1")); err == nil {
+				t.Errorf("Lookup() expected error for cancelled context")
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("missing database uses snapshot", func(t *testing.T) {
+		defer func(f func() ([]db.PrefixInfo, error)) { embedded = f }(embedded)
+		embedded = func() ([]db.PrefixInfo, error) { return testPrefixes, nil }
+
+		path := filepath.Join(dir, "missing.db")
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer l.Close()
+		if _, ok := l.(nopCloser); !ok {
+			t.Errorf("Open() = %T, want embedded snapshot lookuper", l)
+		}
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Open() created database file %s", path)
+		}
+	})
+
+	t.Run("missing database with empty snapshot", func(t *testing.T) {
+		defer func(f func() ([]db.PrefixInfo, error)) { embedded = f }(embedded)
+		embedded = func() ([]db.PrefixInfo, error) { return []db.PrefixInfo{}, nil }
+
+		if _, err := Open(filepath.Join(dir, "missing.db")); !errors.Is(err, ErrEmptySnapshot) {
+			t.Errorf("Open() error = %v, want %v", err, ErrEmptySnapshot)
+		}
+	})
+
+	t.Run("existing database", func(t *testing.T) {
+		path := filepath.Join(dir, "cloudprefixes.db")
+		manager, err := db.NewPrefixManager(path)
+		if err != nil {
+			t.Fatalf("Failed to create PrefixManager: %v", err)
+		}
+		if err := manager.AddPrefixBatch(testPrefixes); err != nil {
+			t.Fatalf("Failed to add prefixes: %v", err)
+		}
+		manager.Close()
+
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer l.Close()
+		got, err := l.Lookup(context.Background(), netip.MustParseAddr("192.30.252.1"))
+		if err != nil {
+			t.Fatalf("Lookup() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Errorf("Lookup() len = %d, want 2", len(got))
+		}
+	})
+}
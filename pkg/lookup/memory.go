@@ -0,0 +1,94 @@
+package lookup
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// MemoryLookuper answers lookups from an in-memory index of prefixes.
+//
+// Prefixes are indexed by their length so a lookup only needs one map access
+// per distinct prefix length rather than a scan over every prefix.
+type MemoryLookuper struct {
+	byBits  map[int]map[netip.Prefix][]db.PrefixInfo
+	lengths []int
+}
+
+// NewMemoryLookuper builds an index over infos. It returns an error if any
+// entry does not contain a valid CIDR prefix.
+func NewMemoryLookuper(infos []db.PrefixInfo) (*MemoryLookuper, error) {
+	l := &MemoryLookuper{byBits: map[int]map[netip.Prefix][]db.PrefixInfo{}}
+	for _, info := range infos {
+		if err := l.add(info); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *MemoryLookuper) add(info db.PrefixInfo) error {
+	prefix, err := netip.ParsePrefix(info.Prefix)
+	if err != nil {
+		return fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+	}
+	prefix = prefix.Masked()
+
+	// store IPv4 prefixes in the IPv4-mapped IPv6 space to give both address
+	// families a single set of lengths
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), bits+96)
+		bits += 96
+	}
+
+	m, ok := l.byBits[bits]
+	if !ok {
+		m = map[netip.Prefix][]db.PrefixInfo{}
+		l.byBits[bits] = m
+		l.lengths = append(l.lengths, bits)
+		sort.Sort(sort.Reverse(sort.IntSlice(l.lengths)))
+	}
+	m[prefix] = append(m[prefix], info)
+	return nil
+}
+
+// Lookup returns matching prefixes ordered from most to least specific.
+func (l *MemoryLookuper) Lookup(ctx context.Context, addr netip.Addr, opts ...Option) ([]db.PrefixInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if !addr.IsValid() {
+		return nil, fmt.Errorf("invalid IP address")
+	}
+
+	is4 := addr.Unmap().Is4()
+	addr = netip.AddrFrom16(addr.As16())
+
+	f := newFilter(opts)
+	results := []db.PrefixInfo{}
+	for _, bits := range l.lengths {
+		// IPv4 prefixes all have at least 96 bits in the mapped space, anything
+		// shorter is an IPv6 prefix and can't contain an IPv4 address
+		if is4 && bits < 96 {
+			continue
+		}
+		prefix, err := addr.Prefix(bits)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range l.byBits[bits][prefix] {
+			if f.match(info) {
+				results = append(results, info)
+			}
+		}
+	}
+	return results, nil
+}
+
+func (l *MemoryLookuper) LookupBatch(ctx context.Context, addrs []netip.Addr, opts ...Option) ([]Result, error) {
+	return batch(ctx, l, addrs, opts...)
+}
@@ -0,0 +1,75 @@
+package lookup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/snapshot"
+)
+
+// LookupCloser is a Lookuper holding resources that must be released.
+type LookupCloser interface {
+	Lookuper
+	io.Closer
+}
+
+// ErrEmptySnapshot is returned by Open when there is no database and the
+// binary was built without generating the embedded snapshot.
+var ErrEmptySnapshot = errors.New("no database and the embedded snapshot is empty, run cloudprefixes -update to create one")
+
+// embedded loads the embedded snapshot, and is replaced in tests.
+var embedded = snapshot.Embedded
+
+// NewSnapshotLookuper returns an in-memory Lookuper over the prefixes
+// embedded in the binary by the snapshot package.
+func NewSnapshotLookuper() (*MemoryLookuper, error) {
+	infos, err := embedded()
+	if err != nil {
+		return nil, fmt.Errorf("error loading embedded snapshot: %v", err)
+	}
+	return NewMemoryLookuper(infos)
+}
+
+// Open returns a Lookuper for the database at dbPath. When no database exists
+// at dbPath, it falls back to the snapshot embedded in the binary so lookups
+// work without any setup, or returns ErrEmptySnapshot if the snapshot holds no
+// prefixes rather than silently finding nothing.
+func Open(dbPath string) (LookupCloser, error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+		l, err := NewSnapshotLookuper()
+		if err != nil {
+			return nil, err
+		}
+		if len(l.lengths) == 0 {
+			return nil, fmt.Errorf("%w: %s not found", ErrEmptySnapshot, dbPath)
+		}
+		return nopCloser{l}, nil
+	}
+
+	manager, err := db.NewPrefixManager(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return &dbCloser{NewDBLookuper(manager)}, nil
+}
+
+type nopCloser struct {
+	Lookuper
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// dbCloser closes the PrefixManager it opened.
+type dbCloser struct {
+	*DBLookuper
+}
+
+func (l *dbCloser) Close() error {
+	return l.manager.Close()
+}
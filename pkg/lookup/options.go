@@ -0,0 +1,87 @@
+package lookup
+
+import "github.com/mchaffe/cloudprefixes/pkg/db"
+
+// Option restricts the prefixes returned by a lookup.
+type Option func(*filter)
+
+type filter struct {
+	platforms  []string
+	services   []string
+	regions    []string
+	categories []string
+}
+
+// WithPlatform only returns prefixes belonging to one of the given platforms,
+// e.g. "AWS" or "GitHub".
+func WithPlatform(platforms ...string) Option {
+	return func(f *filter) {
+		f.platforms = append(f.platforms, platforms...)
+	}
+}
+
+// WithService only returns prefixes associated with one of the given
+// services, e.g. "EC2" or "hooks".
+func WithService(services ...string) Option {
+	return func(f *filter) {
+		f.services = append(f.services, services...)
+	}
+}
+
+// WithRegion only returns prefixes in one of the given regions.
+func WithRegion(regions ...string) Option {
+	return func(f *filter) {
+		f.regions = append(f.regions, regions...)
+	}
+}
+
+// WithCategory only returns prefixes in one of the given categories, e.g.
+// "bot" to check whether an address belongs to a verified crawler.
+func WithCategory(categories ...string) Option {
+	return func(f *filter) {
+		f.categories = append(f.categories, categories...)
+	}
+}
+
+func newFilter(opts []Option) *filter {
+	f := &filter{}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// match reports whether info passes every restriction in the filter. Empty
+// restrictions match everything.
+func (f *filter) match(info db.PrefixInfo) bool {
+	return matchAny(f.platforms, &info.Platform) &&
+		matchAny(f.services, info.Service) &&
+		matchAny(f.regions, info.Region) &&
+		matchAny(f.categories, info.Category)
+}
+
+func matchAny(want []string, got *string) bool {
+	if len(want) == 0 {
+		return true
+	}
+	if got == nil {
+		return false
+	}
+	for _, w := range want {
+		if w == *got {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the entries of infos that match the filter.
+func (f *filter) apply(infos []db.PrefixInfo) []db.PrefixInfo {
+	results := []db.PrefixInfo{}
+	for _, info := range infos {
+		if f.match(info) {
+			results = append(results, info)
+		}
+	}
+	return results
+}
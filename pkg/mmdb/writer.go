@@ -0,0 +1,335 @@
+// Package mmdb writes MaxMind DB files.
+//
+// Only the subset of the format needed to publish prefix attribution data is
+// implemented: an IPv6 search tree with 32 bit records, IPv4 networks stored
+// in the ::/96 subtree, and data values built from strings, numbers, booleans,
+// slices and maps. The format is described at
+// https://maxmind.github.io/MaxMind-DB/
+package mmdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+const recordSize = 32
+
+// metadataMarker separates the data section from the metadata.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// Writer builds a MaxMind DB in memory and serializes it with WriteTo.
+type Writer struct {
+	DatabaseType string
+	Description  string
+	Languages    []string
+	BuildTime    time.Time
+
+	root *node
+}
+
+type node struct {
+	children [2]*node
+	value    any
+	leaf     bool
+}
+
+// NewWriter returns a Writer for a database of the given type.
+func NewWriter(databaseType, description string) *Writer {
+	return &Writer{
+		DatabaseType: databaseType,
+		Description:  description,
+		Languages:    []string{"en"},
+		BuildTime:    time.Now(),
+		root:         &node{},
+	}
+}
+
+// Insert stores value for every address in prefix, replacing anything
+// previously inserted for the prefix or networks inside it. Inserting prefixes
+// from least to most specific therefore makes the most specific prefix win.
+func (w *Writer) Insert(prefix netip.Prefix, value any) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("invalid prefix %v", prefix)
+	}
+	prefix = prefix.Masked()
+
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4() {
+		// IPv4 networks live in ::/96 so readers can find them by prepending
+		// 96 zero bits to the address
+		var b [16]byte
+		v4 := addr.As4()
+		copy(b[12:], v4[:])
+		addr = netip.AddrFrom16(b)
+		bits += 96
+	}
+	ip := addr.As16()
+
+	n := w.root
+	for i := 0; i < bits; i++ {
+		if n.leaf {
+			n.split()
+		}
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &node{}
+		}
+		n = n.children[bit]
+	}
+	n.children = [2]*node{}
+	n.value = value
+	n.leaf = true
+	return nil
+}
+
+// split turns a leaf into an internal node whose halves both keep its value.
+func (n *node) split() {
+	n.children[0] = &node{value: n.value, leaf: true}
+	n.children[1] = &node{value: n.value, leaf: true}
+	n.leaf = false
+	n.value = nil
+}
+
+// WriteTo serializes the database to out.
+func (w *Writer) WriteTo(out io.Writer) (int64, error) {
+	// the tree needs at least one node, even when ::/0 was inserted
+	if w.root.leaf {
+		w.root.split()
+	}
+
+	// number the internal nodes breadth first, the root must be node 0
+	var nodes []*node
+	ids := map[*node]uint32{}
+	queue := []*node{w.root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.leaf {
+			continue
+		}
+		ids[n] = uint32(len(nodes))
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil && !c.leaf {
+				queue = append(queue, c)
+			}
+		}
+	}
+	nodeCount := uint32(len(nodes))
+
+	data := newDataSection()
+	record := func(c *node) (uint32, error) {
+		if c == nil || (c.leaf && c.value == nil) {
+			return nodeCount, nil
+		}
+		if !c.leaf {
+			return ids[c], nil
+		}
+		offset, err := data.add(c.value)
+		if err != nil {
+			return 0, err
+		}
+		return nodeCount + 16 + offset, nil
+	}
+
+	tree := make([]byte, 0, len(nodes)*recordSize/4)
+	for _, n := range nodes {
+		for _, c := range n.children {
+			r, err := record(c)
+			if err != nil {
+				return 0, err
+			}
+			tree = binary.BigEndian.AppendUint32(tree, r)
+		}
+	}
+	if uint64(nodeCount)+16+uint64(len(data.buf)) > math.MaxUint32 {
+		return 0, fmt.Errorf("database too large for %d bit records", recordSize)
+	}
+
+	languages := make([]any, len(w.Languages))
+	for i, l := range w.Languages {
+		languages[i] = l
+	}
+	metadata, err := encode(map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(w.BuildTime.Unix()),
+		"database_type":               w.DatabaseType,
+		"description":                 map[string]any{"en": w.Description},
+		"ip_version":                  uint16(6),
+		"languages":                   languages,
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(recordSize),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(out)
+	var written int64
+	for _, b := range [][]byte{tree, make([]byte, 16), data.buf, metadataMarker, metadata} {
+		n, err := bw.Write(b)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, bw.Flush()
+}
+
+// dataSection deduplicates identical values so networks sharing the same
+// attribution also share one copy of it.
+type dataSection struct {
+	buf     []byte
+	offsets map[string]uint32
+}
+
+func newDataSection() *dataSection {
+	return &dataSection{offsets: map[string]uint32{}}
+}
+
+func (d *dataSection) add(v any) (uint32, error) {
+	b, err := encode(v)
+	if err != nil {
+		return 0, err
+	}
+	if offset, ok := d.offsets[string(b)]; ok {
+		return offset, nil
+	}
+	offset := uint32(len(d.buf))
+	d.offsets[string(b)] = offset
+	d.buf = append(d.buf, b...)
+	return offset, nil
+}
+
+// MaxMind DB data types
+const (
+	typeString  = 2
+	typeDouble  = 3
+	typeUint16  = 5
+	typeUint32  = 6
+	typeMap     = 7
+	typeInt32   = 8
+	typeUint64  = 9
+	typeArray   = 11
+	typeBoolean = 14
+)
+
+func encode(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendValue(b []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case string:
+		b = appendControl(b, typeString, len(v))
+		return append(b, v...), nil
+	case float64:
+		b = appendControl(b, typeDouble, 8)
+		return binary.BigEndian.AppendUint64(b, math.Float64bits(v)), nil
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		return appendControl(b, typeBoolean, size), nil
+	case uint16:
+		return appendUint(b, typeUint16, uint64(v)), nil
+	case uint32:
+		return appendUint(b, typeUint32, uint64(v)), nil
+	case uint64:
+		return appendUint(b, typeUint64, v), nil
+	case int:
+		if v < 0 {
+			if v < math.MinInt32 {
+				return nil, fmt.Errorf("integer %d out of range", v)
+			}
+			b = appendControl(b, typeInt32, 4)
+			return binary.BigEndian.AppendUint32(b, uint32(int32(v))), nil
+		}
+		return appendUint(b, typeUint64, uint64(v)), nil
+	case []string:
+		b = appendControl(b, typeArray, len(v))
+		for _, s := range v {
+			b, _ = appendValue(b, s)
+		}
+		return b, nil
+	case []any:
+		b = appendControl(b, typeArray, len(v))
+		for _, e := range v {
+			var err error
+			if b, err = appendValue(b, e); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]any:
+		// sort keys so equal maps always encode to the same bytes
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendControl(b, typeMap, len(keys))
+		for _, k := range keys {
+			b, _ = appendValue(b, k)
+			var err error
+			if b, err = appendValue(b, v[k]); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported data type %T", v)
+}
+
+func appendUint(b []byte, typ int, v uint64) []byte {
+	size := 0
+	for x := v; x > 0; x >>= 8 {
+		size++
+	}
+	b = appendControl(b, typ, size)
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*i)))
+	}
+	return b
+}
+
+// appendControl writes the control byte(s) for a field of the given type and
+// size, including the extended type byte and any extra size bytes.
+func appendControl(b []byte, typ int, size int) []byte {
+	ctrl := byte(0)
+	if typ <= 7 {
+		ctrl = byte(typ) << 5
+	}
+
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	case size < 285+65536:
+		ctrl |= 30
+		s := size - 285
+		extra = []byte{byte(s >> 8), byte(s)}
+	default:
+		ctrl |= 31
+		s := size - 65821
+		extra = []byte{byte(s >> 16), byte(s >> 8), byte(s)}
+	}
+
+	b = append(b, ctrl)
+	if typ > 7 {
+		b = append(b, byte(typ-7))
+	}
+	return append(b, extra...)
+}
@@ -0,0 +1,202 @@
+package mmdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+// reader is a minimal MaxMind DB reader used to check the writer output.
+type reader struct {
+	tree     []byte
+	data     []byte
+	metadata map[string]any
+	nodes    uint32
+}
+
+func newReader(b []byte) (*reader, error) {
+	i := bytes.LastIndex(b, metadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("metadata marker not found")
+	}
+	meta, _, err := decode(b[i+len(metadataMarker):], 0)
+	if err != nil {
+		return nil, err
+	}
+	m := meta.(map[string]any)
+	nodes := uint32(m["node_count"].(uint64))
+	treeSize := int(nodes) * 8
+	return &reader{
+		tree:     b[:treeSize],
+		data:     b[treeSize+16 : i],
+		metadata: m,
+		nodes:    nodes,
+	}, nil
+}
+
+func (r *reader) lookup(addr netip.Addr) (any, error) {
+	ip := addr.As16()
+	if addr.Is4() {
+		ip = [16]byte{}
+		v4 := addr.As4()
+		copy(ip[12:], v4[:])
+	}
+	n := uint32(0)
+	for i := 0; i < 128 && n < r.nodes; i++ {
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		n = binary.BigEndian.Uint32(r.tree[n*8+uint32(bit)*4:])
+	}
+	if n == r.nodes {
+		return nil, nil
+	}
+	v, _, err := decode(r.data, int(n-r.nodes-16))
+	return v, err
+}
+
+func decode(b []byte, offset int) (any, int, error) {
+	ctrl := b[offset]
+	offset++
+	typ := int(ctrl >> 5)
+	if typ == 0 {
+		typ = int(b[offset]) + 7
+		offset++
+	}
+	size := int(ctrl & 0x1f)
+	switch size {
+	case 29:
+		size = 29 + int(b[offset])
+		offset++
+	case 30:
+		size = 285 + (int(b[offset])<<8 | int(b[offset+1]))
+		offset += 2
+	case 31:
+		size = 65821 + (int(b[offset])<<16 | int(b[offset+1])<<8 | int(b[offset+2]))
+		offset += 3
+	}
+
+	switch typ {
+	case typeString:
+		return string(b[offset : offset+size]), offset + size, nil
+	case typeDouble:
+		return math.Float64frombits(binary.BigEndian.Uint64(b[offset:])), offset + 8, nil
+	case typeUint16, typeUint32, typeUint64:
+		var v uint64
+		for _, c := range b[offset : offset+size] {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset + size, nil
+	case typeInt32:
+		return int(int32(binary.BigEndian.Uint32(b[offset:]))), offset + 4, nil
+	case typeBoolean:
+		return size == 1, offset, nil
+	case typeArray:
+		a := make([]any, size)
+		for i := range a {
+			var err error
+			if a[i], offset, err = decode(b, offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case typeMap:
+		m := map[string]any{}
+		for i := 0; i < size; i++ {
+			k, next, err := decode(b, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k.(string)], offset, err = decode(b, next)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	}
+	return nil, 0, fmt.Errorf("unexpected type %d", typ)
+}
+
+func TestWriter(t *testing.T) {
+	w := NewWriter("Test-DB", "test database")
+	inserts := []struct {
+		prefix string
+		value  any
+	}{
+		{"10.0.0.0/8", map[string]any{"name": "ten"}},
+		{"10.1.0.0/16", map[string]any{"name": "ten-one", "tags": []string{"a", "b"}}},
+		{"2600:1f13::/36", map[string]any{"name": "aws", "n": uint32(70000), "ok": true, "f": 1.5}},
+		{"2600:1f13:a0d:a700::/56", map[string]any{"name": "aws-nested", "neg": -3}},
+	}
+	for _, i := range inserts {
+		if err := w.Insert(netip.MustParsePrefix(i.prefix), i.value); err != nil {
+			t.Fatalf("Insert(%s) error = %v", i.prefix, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+
+	r, err := newReader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("failed to read database: %v", err)
+	}
+	if r.metadata["database_type"] != "Test-DB" {
+		t.Errorf("database_type = %v, want Test-DB", r.metadata["database_type"])
+	}
+	if r.metadata["ip_version"] != uint64(6) || r.metadata["record_size"] != uint64(32) {
+		t.Errorf("unexpected metadata %v", r.metadata)
+	}
+
+	tests := []struct {
+		ip   string
+		want any
+	}{
+		{"10.2.3.4", map[string]any{"name": "ten"}},
+		{"10.1.3.4", map[string]any{"name": "ten-one", "tags": []any{"a", "b"}}},
+		{"11.0.0.1", nil},
+		{"2600:1f13:1::1", map[string]any{"name": "aws", "n": uint64(70000), "ok": true, "f": 1.5}},
+		{"2600:1f13:a0d:a700::1", map[string]any{"name": "aws-nested", "neg": -3}},
+		{"2001:db8::1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			got, err := r.lookup(netip.MustParseAddr(tt.ip))
+			if err != nil {
+				t.Fatalf("lookup() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriter_LongString(t *testing.T) {
+	for _, size := range []int{28, 29, 284, 285, 70000} {
+		s := string(bytes.Repeat([]byte("x"), size))
+		b, err := encode(s)
+		if err != nil {
+			t.Fatalf("encode() error = %v", err)
+		}
+		got, _, err := decode(b, 0)
+		if err != nil {
+			t.Fatalf("decode() error = %v", err)
+		}
+		if got != s {
+			t.Errorf("round trip of %d byte string failed", size)
+		}
+	}
+}
+
+func TestWriter_UnsupportedType(t *testing.T) {
+	w := NewWriter("Test-DB", "test database")
+	w.Insert(netip.MustParsePrefix("10.0.0.0/8"), struct{}{})
+	if _, err := w.WriteTo(&bytes.Buffer{}); err == nil {
+		t.Errorf("WriteTo() expected error for unsupported type")
+	}
+}
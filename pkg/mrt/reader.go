@@ -0,0 +1,224 @@
+// Package mrt reads the routes of BGP RIB dumps in the MRT format.
+//
+// Only the TABLE_DUMP_V2 RIB records written by RouteViews and RIPE RIS are
+// decoded, including the ADD-PATH variants, and only as far as needed to find
+// each prefix's origin AS. Other records are skipped. The format is described
+// in RFC 6396 and RFC 8050.
+package mrt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"time"
+)
+
+const (
+	typeTableDumpV2 = 13
+
+	subtypeRIBIPv4Unicast          = 2
+	subtypeRIBIPv4Multicast        = 3
+	subtypeRIBIPv6Unicast          = 4
+	subtypeRIBIPv6Multicast        = 5
+	subtypeRIBIPv4UnicastAddPath   = 8
+	subtypeRIBIPv4MulticastAddPath = 9
+	subtypeRIBIPv6UnicastAddPath   = 10
+	subtypeRIBIPv6MulticastAddPath = 11
+
+	attrASPath = 2
+
+	segmentASSet      = 1
+	segmentASSequence = 2
+
+	// maxRecordLength bounds the memory a corrupt length field can claim.
+	maxRecordLength = 16 << 20
+)
+
+// RIB holds the routes to a prefix from every peer in a dump.
+type RIB struct {
+	// Time is when the dump was made.
+	Time   time.Time
+	Prefix netip.Prefix
+	// Origins are the distinct origin ASes of the routes, in the order first
+	// seen. A route whose AS path ends in an AS_SET contributes each member.
+	Origins []uint32
+}
+
+// Reader reads the RIB records of an MRT dump in order.
+type Reader struct {
+	r      *bufio.Reader
+	header [12]byte
+}
+
+// NewReader returns a Reader reading an uncompressed dump from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next RIB record, skipping records of other types. It
+// returns io.EOF at the end of the dump.
+func (r *Reader) Next() (RIB, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return RIB{}, errors.New("truncated MRT header")
+			}
+			return RIB{}, err
+		}
+		timestamp := binary.BigEndian.Uint32(r.header[0:4])
+		recordType := binary.BigEndian.Uint16(r.header[4:6])
+		subtype := binary.BigEndian.Uint16(r.header[6:8])
+		length := binary.BigEndian.Uint32(r.header[8:12])
+		if length > maxRecordLength {
+			return RIB{}, fmt.Errorf("MRT record length %d too large", length)
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.r, body); err != nil {
+			return RIB{}, fmt.Errorf("truncated MRT record: %v", err)
+		}
+		if recordType != typeTableDumpV2 {
+			continue
+		}
+
+		var ipv6, addPath bool
+		switch subtype {
+		case subtypeRIBIPv4Unicast, subtypeRIBIPv4Multicast:
+		case subtypeRIBIPv6Unicast, subtypeRIBIPv6Multicast:
+			ipv6 = true
+		case subtypeRIBIPv4UnicastAddPath, subtypeRIBIPv4MulticastAddPath:
+			addPath = true
+		case subtypeRIBIPv6UnicastAddPath, subtypeRIBIPv6MulticastAddPath:
+			ipv6, addPath = true, true
+		default:
+			// the peer index table and RIB_GENERIC records
+			continue
+		}
+
+		rib, err := parseRIB(body, ipv6, addPath)
+		if err != nil {
+			return RIB{}, err
+		}
+		rib.Time = time.Unix(int64(timestamp), 0).UTC()
+		return rib, nil
+	}
+}
+
+// parseRIB decodes the body of a RIB_IPV4_* or RIB_IPV6_* record.
+func parseRIB(b []byte, ipv6, addPath bool) (RIB, error) {
+	var rib RIB
+	if len(b) < 5 {
+		return rib, errors.New("truncated RIB record")
+	}
+	bits := int(b[4])
+	size := 4
+	if ipv6 {
+		size = 16
+	}
+	if bits > size*8 {
+		return rib, fmt.Errorf("invalid RIB prefix length %d", bits)
+	}
+	n := (bits + 7) / 8
+	b = b[5:]
+	if len(b) < n+2 {
+		return rib, errors.New("truncated RIB record")
+	}
+	addr := make([]byte, size)
+	copy(addr, b[:n])
+	ip, _ := netip.AddrFromSlice(addr)
+	rib.Prefix = netip.PrefixFrom(ip, bits).Masked()
+
+	entries := int(binary.BigEndian.Uint16(b[n:]))
+	b = b[n+2:]
+	for i := 0; i < entries; i++ {
+		// peer index and originated time, then the path identifier with
+		// ADD-PATH
+		skip := 6
+		if addPath {
+			skip += 4
+		}
+		if len(b) < skip+2 {
+			return rib, errors.New("truncated RIB entry")
+		}
+		attrLength := int(binary.BigEndian.Uint16(b[skip:]))
+		b = b[skip+2:]
+		if len(b) < attrLength {
+			return rib, errors.New("truncated RIB entry attributes")
+		}
+		origins, err := parseOrigins(b[:attrLength])
+		if err != nil {
+			return rib, fmt.Errorf("invalid RIB entry for %s: %v", rib.Prefix, err)
+		}
+		for _, o := range origins {
+			if !slices.Contains(rib.Origins, o) {
+				rib.Origins = append(rib.Origins, o)
+			}
+		}
+		b = b[attrLength:]
+	}
+	return rib, nil
+}
+
+// parseOrigins returns the origin ASes of the AS_PATH in a list of BGP path
+// attributes. TABLE_DUMP_V2 always encodes AS numbers in 4 bytes.
+func parseOrigins(b []byte) ([]uint32, error) {
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errors.New("truncated attribute")
+		}
+		flags, attrType := b[0], b[1]
+		var length int
+		if flags&0x10 != 0 {
+			if len(b) < 4 {
+				return nil, errors.New("truncated attribute")
+			}
+			length = int(binary.BigEndian.Uint16(b[2:]))
+			b = b[4:]
+		} else {
+			length = int(b[2])
+			b = b[3:]
+		}
+		if len(b) < length {
+			return nil, errors.New("truncated attribute")
+		}
+		if attrType == attrASPath {
+			return pathOrigins(b[:length])
+		}
+		b = b[length:]
+	}
+	return nil, nil
+}
+
+// pathOrigins returns the last AS of the final segment of an AS_PATH, or every
+// AS when the final segment is an AS_SET. Confederation segments are ignored.
+func pathOrigins(b []byte) ([]uint32, error) {
+	var origins []uint32
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errors.New("truncated AS_PATH segment")
+		}
+		segmentType, count := b[0], int(b[1])
+		b = b[2:]
+		if len(b) < count*4 {
+			return nil, errors.New("truncated AS_PATH segment")
+		}
+		asns := make([]uint32, count)
+		for i := range asns {
+			asns[i] = binary.BigEndian.Uint32(b[i*4:])
+		}
+		b = b[count*4:]
+
+		switch {
+		case count == 0:
+		case segmentType == segmentASSequence:
+			origins = asns[count-1:]
+		case segmentType == segmentASSet:
+			origins = asns
+		}
+	}
+	return origins, nil
+}
@@ -0,0 +1,125 @@
+package mrt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// record returns an MRT record with the given type, subtype and body.
+func record(recordType, subtype uint16, body []byte) []byte {
+	b := make([]byte, 12, 12+len(body))
+	binary.BigEndian.PutUint32(b[0:], 1727740800)
+	binary.BigEndian.PutUint16(b[4:], recordType)
+	binary.BigEndian.PutUint16(b[6:], subtype)
+	binary.BigEndian.PutUint32(b[8:], uint32(len(body)))
+	return append(b, body...)
+}
+
+// segment returns an AS_PATH segment.
+func segment(segmentType byte, asns ...uint32) []byte {
+	b := []byte{segmentType, byte(len(asns))}
+	for _, asn := range asns {
+		b = binary.BigEndian.AppendUint32(b, asn)
+	}
+	return b
+}
+
+// entry returns a RIB entry whose attributes are ORIGIN and an AS_PATH made of
+// segments, using the extended length encoding when extended is set.
+func entry(addPath, extended bool, segments ...[]byte) []byte {
+	path := bytes.Join(segments, nil)
+	attrs := []byte{0x40, 1, 1, 0}
+	if extended {
+		attrs = append(attrs, 0x50, attrASPath)
+		attrs = binary.BigEndian.AppendUint16(attrs, uint16(len(path)))
+	} else {
+		attrs = append(attrs, 0x40, attrASPath, byte(len(path)))
+	}
+	attrs = append(attrs, path...)
+
+	b := []byte{0, 1, 0x66, 0xfb, 0x4a, 0x80}
+	if addPath {
+		b = append(b, 0, 0, 0, 1)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(attrs)))
+	return append(b, attrs...)
+}
+
+// rib returns the body of a RIB record for prefix with entries.
+func rib(prefix string, entries ...[]byte) []byte {
+	p := netip.MustParsePrefix(prefix)
+	b := []byte{0, 0, 0, 7, byte(p.Bits())}
+	b = append(b, p.Addr().AsSlice()[:(p.Bits()+7)/8]...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(entries)))
+	return append(b, bytes.Join(entries, nil)...)
+}
+
+func TestReader_Next(t *testing.T) {
+	var dump []byte
+	// peer index table and a BGP4MP message are skipped
+	dump = append(dump, record(typeTableDumpV2, 1, []byte{1, 2, 3, 4, 0, 0, 0, 0})...)
+	dump = append(dump, record(16, 4, []byte{0xff})...)
+	dump = append(dump, record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib("1.1.1.0/24",
+		entry(false, false, segment(segmentASSequence, 3356, 13335)),
+		entry(false, true, segment(segmentASSequence, 174, 13335)),
+	))...)
+	dump = append(dump, record(typeTableDumpV2, subtypeRIBIPv6Unicast, rib("2a01:4f8::/29",
+		entry(false, false, segment(segmentASSequence, 6939, 24940)),
+	))...)
+	dump = append(dump, record(typeTableDumpV2, subtypeRIBIPv4UnicastAddPath, rib("192.0.2.0/23",
+		entry(true, false, segment(segmentASSequence, 64500), segment(segmentASSet, 64501, 64502)),
+		entry(true, false, segment(segmentASSequence, 64503, 64501)),
+	))...)
+	dump = append(dump, record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib("0.0.0.0/0"))...)
+
+	at := time.Unix(1727740800, 0).UTC()
+	want := []RIB{
+		{Time: at, Prefix: netip.MustParsePrefix("1.1.1.0/24"), Origins: []uint32{13335}},
+		{Time: at, Prefix: netip.MustParsePrefix("2a01:4f8::/29"), Origins: []uint32{24940}},
+		{Time: at, Prefix: netip.MustParsePrefix("192.0.2.0/23"), Origins: []uint32{64501, 64502}},
+		{Time: at, Prefix: netip.MustParsePrefix("0.0.0.0/0")},
+	}
+
+	r := NewReader(bytes.NewReader(dump))
+	var got []RIB
+	for {
+		rib, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reader.Next() error = %v", err)
+		}
+		got = append(got, rib)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Reader.Next() = %v, want %v", got, want)
+	}
+}
+
+func TestReader_Invalid(t *testing.T) {
+	valid := record(typeTableDumpV2, subtypeRIBIPv4Unicast, rib("1.1.1.0/24",
+		entry(false, false, segment(segmentASSequence, 13335)),
+	))
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Truncated header", valid[:6]},
+		{"Truncated body", valid[:len(valid)-1]},
+		{"Truncated entry", record(typeTableDumpV2, subtypeRIBIPv4Unicast, valid[12:len(valid)-3])},
+		{"Prefix too long", record(typeTableDumpV2, subtypeRIBIPv4Unicast, []byte{0, 0, 0, 1, 33, 1, 1, 1, 1, 1, 0, 0})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tt.data)).Next(); err == nil || err == io.EOF {
+				t.Errorf("Reader.Next() error = %v, want error", err)
+			}
+		})
+	}
+}
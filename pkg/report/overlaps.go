@@ -0,0 +1,211 @@
+// Package report analyses the contents of the prefix database.
+package report
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Relations between overlapping claims.
+const (
+	// Identical claims are for the same prefix.
+	Identical = "identical"
+	// Nested claims have one prefix inside the other.
+	Nested = "nested"
+	// Partial is only used for platform summaries: each platform claims
+	// overlapping space the other doesn't. Two CIDR prefixes can never
+	// partially overlap, so it never describes a single pair of claims.
+	Partial = "partial"
+)
+
+// Claim is a prefix attributed to a platform, with every service the
+// platform lists for it.
+type Claim struct {
+	Prefix   string   `json:"prefix"`
+	Platform string   `json:"platform"`
+	Services []string `json:"services,omitempty"`
+
+	prefix netip.Prefix
+}
+
+// Overlap is a pair of claims by different platforms covering the same
+// addresses. Outer is the shorter prefix, or for identical claims the one
+// whose platform sorts first.
+type Overlap struct {
+	Relation string `json:"relation"`
+	Outer    Claim  `json:"outer"`
+	Inner    Claim  `json:"inner"`
+}
+
+// PlatformOverlap summarises the overlaps between two platforms by comparing
+// the address space each claims within the overlapping prefixes.
+type PlatformOverlap struct {
+	Platforms [2]string `json:"platforms"`
+	Relation  string    `json:"relation"`
+	Overlaps  int       `json:"overlaps"`
+}
+
+// OverlapReport lists every overlap between platforms and a summary per pair
+// of platforms.
+type OverlapReport struct {
+	Overlaps []Overlap         `json:"overlaps"`
+	Summary  []PlatformOverlap `json:"summary"`
+}
+
+// Overlaps finds the prefixes in infos claimed by more than one platform.
+// Overlaps between services of the same platform are expected, e.g. AWS lists
+// blocks under both AMAZON and EC2, and are not reported.
+func Overlaps(infos []db.PrefixInfo) (OverlapReport, error) {
+	claims, err := claimsByPlatform(infos)
+	if err != nil {
+		return OverlapReport{}, err
+	}
+
+	// claims are sorted by address then length, so every claim containing
+	// the current one is on the stack
+	overlaps := []Overlap{}
+	var stack []*Claim
+	for i := range claims {
+		c := &claims[i]
+		for len(stack) > 0 && !stack[len(stack)-1].prefix.Contains(c.prefix.Addr()) {
+			stack = stack[:len(stack)-1]
+		}
+		for _, outer := range stack {
+			if outer.Platform == c.Platform {
+				continue
+			}
+			relation := Nested
+			if outer.prefix == c.prefix {
+				relation = Identical
+			}
+			overlaps = append(overlaps, Overlap{Relation: relation, Outer: *outer, Inner: *c})
+		}
+		stack = append(stack, c)
+	}
+
+	return OverlapReport{Overlaps: overlaps, Summary: summarise(overlaps)}, nil
+}
+
+// claimsByPlatform merges infos into one claim per prefix and platform,
+// sorted by address and then length.
+func claimsByPlatform(infos []db.PrefixInfo) ([]Claim, error) {
+	type key struct {
+		prefix   netip.Prefix
+		platform string
+	}
+	index := map[key]int{}
+	var claims []Claim
+	for _, info := range infos {
+		p, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		k := key{p.Masked(), info.Platform}
+		i, ok := index[k]
+		if !ok {
+			i = len(claims)
+			index[k] = i
+			claims = append(claims, Claim{Prefix: k.prefix.String(), Platform: info.Platform, prefix: k.prefix})
+		}
+		if info.Service != nil && *info.Service != "" && !contains(claims[i].Services, *info.Service) {
+			claims[i].Services = append(claims[i].Services, *info.Service)
+		}
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		a, b := claims[i].prefix, claims[j].prefix
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c < 0
+		}
+		if a.Bits() != b.Bits() {
+			return a.Bits() < b.Bits()
+		}
+		return claims[i].Platform < claims[j].Platform
+	})
+	return claims, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// summarise compares, for each pair of platforms, the space each claims in
+// their overlaps. Equal space is identical, one inside the other is nested,
+// and anything else is partial.
+func summarise(overlaps []Overlap) []PlatformOverlap {
+	type pair [2]string
+	type space struct {
+		count  int
+		claims [2][]netip.Prefix
+	}
+	spaces := map[pair]*space{}
+	var pairs []pair
+	for _, o := range overlaps {
+		claims := [2]Claim{o.Outer, o.Inner}
+		if claims[0].Platform > claims[1].Platform {
+			claims[0], claims[1] = claims[1], claims[0]
+		}
+		p := pair{claims[0].Platform, claims[1].Platform}
+		s, ok := spaces[p]
+		if !ok {
+			s = &space{}
+			spaces[p] = s
+			pairs = append(pairs, p)
+		}
+		s.count++
+		for i, c := range claims {
+			s.claims[i] = append(s.claims[i], c.prefix)
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+
+	summary := []PlatformOverlap{}
+	for _, p := range pairs {
+		s := spaces[p]
+		a := cidr.Aggregate(s.claims[0])
+		b := cidr.Aggregate(s.claims[1])
+		relation := Partial
+		switch {
+		case equal(a, b):
+			relation = Identical
+		case covers(a, b) || covers(b, a):
+			relation = Nested
+		}
+		summary = append(summary, PlatformOverlap{Platforms: p, Relation: relation, Overlaps: s.count})
+	}
+	return summary
+}
+
+func equal(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// covers reports whether the aggregated prefixes outer cover every address
+// in the aggregated prefixes inner.
+func covers(outer, inner []netip.Prefix) bool {
+	union := cidr.Aggregate(append(append([]netip.Prefix{}, outer...), inner...))
+	return equal(union, outer)
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestOverlaps(t *testing.T) {
+	infos := []db.PrefixInfo{
+		{Prefix: "10.0.0.0/16", Platform: "Google"},
+		{Prefix: "10.0.0.0/16", Platform: "GCP", Service: stringPointer("Google Cloud")},
+		{Prefix: "10.0.1.0/24", Platform: "GCP", Service: stringPointer("Google Cloud")},
+		{Prefix: "10.1.0.0/24", Platform: "AWS", Service: stringPointer("AMAZON")},
+		{Prefix: "10.1.0.0/24", Platform: "AWS", Service: stringPointer("EC2")},
+		{Prefix: "10.1.0.0/25", Platform: "AWS", Service: stringPointer("S3")},
+		{Prefix: "10.1.0.0/25", Platform: "Vultr"},
+		{Prefix: "10.2.0.0/24", Platform: "Vultr"},
+		{Prefix: "10.2.0.0/25", Platform: "Linode"},
+		{Prefix: "10.2.1.0/24", Platform: "Linode"},
+		{Prefix: "2001:db8::/32", Platform: "Azure"},
+	}
+
+	got, err := Overlaps(infos)
+	if err != nil {
+		t.Fatalf("Overlaps() error = %v", err)
+	}
+
+	wantOverlaps := []Overlap{
+		{Identical, Claim{Prefix: "10.0.0.0/16", Platform: "GCP", Services: []string{"Google Cloud"}}, Claim{Prefix: "10.0.0.0/16", Platform: "Google"}},
+		{Nested, Claim{Prefix: "10.0.0.0/16", Platform: "Google"}, Claim{Prefix: "10.0.1.0/24", Platform: "GCP", Services: []string{"Google Cloud"}}},
+		{Nested, Claim{Prefix: "10.1.0.0/24", Platform: "AWS", Services: []string{"AMAZON", "EC2"}}, Claim{Prefix: "10.1.0.0/25", Platform: "Vultr"}},
+		{Identical, Claim{Prefix: "10.1.0.0/25", Platform: "AWS", Services: []string{"S3"}}, Claim{Prefix: "10.1.0.0/25", Platform: "Vultr"}},
+		{Nested, Claim{Prefix: "10.2.0.0/24", Platform: "Vultr"}, Claim{Prefix: "10.2.0.0/25", Platform: "Linode"}},
+	}
+	if len(got.Overlaps) != len(wantOverlaps) {
+		t.Fatalf("Overlaps() found %d overlaps, want %d: %+v", len(got.Overlaps), len(wantOverlaps), got.Overlaps)
+	}
+	for i, want := range wantOverlaps {
+		o := got.Overlaps[i]
+		o.Outer.prefix, o.Inner.prefix = want.Outer.prefix, want.Inner.prefix
+		if !reflect.DeepEqual(o, want) {
+			t.Errorf("Overlaps()[%d] = %+v, want %+v", i, o, want)
+		}
+	}
+
+	wantSummary := []PlatformOverlap{
+		{Platforms: [2]string{"AWS", "Vultr"}, Relation: Nested, Overlaps: 2},
+		{Platforms: [2]string{"GCP", "Google"}, Relation: Identical, Overlaps: 2},
+		{Platforms: [2]string{"Linode", "Vultr"}, Relation: Nested, Overlaps: 1},
+	}
+	if !reflect.DeepEqual(got.Summary, wantSummary) {
+		t.Errorf("Overlaps() summary = %+v, want %+v", got.Summary, wantSummary)
+	}
+}
+
+func Test_summarise_Partial(t *testing.T) {
+	// each platform has one prefix nested inside the other's, so neither
+	// covers all the space of the other
+	infos := []db.PrefixInfo{
+		{Prefix: "10.2.0.0/23", Platform: "Linode"},
+		{Prefix: "10.2.0.0/24", Platform: "Vultr"},
+		{Prefix: "10.4.0.0/24", Platform: "Vultr"},
+		{Prefix: "10.4.0.0/25", Platform: "Linode"},
+	}
+	got, err := Overlaps(infos)
+	if err != nil {
+		t.Fatalf("Overlaps() error = %v", err)
+	}
+	want := []PlatformOverlap{{Platforms: [2]string{"Linode", "Vultr"}, Relation: Partial, Overlaps: 2}}
+	if !reflect.DeepEqual(got.Summary, want) {
+		t.Errorf("Overlaps() summary = %+v, want %+v", got.Summary, want)
+	}
+}
+
+func TestOverlaps_InvalidCIDR(t *testing.T) {
+	if _, err := Overlaps([]db.PrefixInfo{{Prefix: "invalid_cidr", Platform: "AWS"}}); err == nil {
+		t.Errorf("Overlaps() expected error for invalid CIDR")
+	}
+}
@@ -0,0 +1,131 @@
+package report
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Count summarises a group of prefixes. Prefixes counts every entry, so a
+// prefix listed under several services is counted once for each, while the
+// address space is that of the aggregated prefixes and so counts every
+// address once. Address space is given in /24 equivalents for IPv4 and /48
+// equivalents for IPv6.
+type Count struct {
+	Platform     string  `json:"platform,omitempty"`
+	Service      string  `json:"service,omitempty"`
+	Region       string  `json:"region,omitempty"`
+	Prefixes     int     `json:"prefixes"`
+	IPv4Prefixes int     `json:"ipv4_prefixes"`
+	IPv6Prefixes int     `json:"ipv6_prefixes"`
+	IPv4Slash24s float64 `json:"ipv4_slash24s"`
+	IPv6Slash48s float64 `json:"ipv6_slash48s"`
+}
+
+// StatsReport counts the prefixes in total, per platform, per service and
+// region of each platform, along with when each source was last fetched.
+type StatsReport struct {
+	Total     Count       `json:"total"`
+	Platforms []Count     `json:"platforms"`
+	Services  []Count     `json:"services"`
+	Regions   []Count     `json:"regions"`
+	Sources   []db.Source `json:"sources"`
+}
+
+// Stats summarises infos and the sources they were fetched from.
+func Stats(infos []db.PrefixInfo, sources []db.Source) (StatsReport, error) {
+	total := newGroups()
+	platforms := newGroups()
+	services := newGroups()
+	regions := newGroups()
+	for _, info := range infos {
+		p, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return StatsReport{}, fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		total.add(Count{}, p)
+		platforms.add(Count{Platform: info.Platform}, p)
+		services.add(Count{Platform: info.Platform, Service: valueOrEmpty(info.Service)}, p)
+		regions.add(Count{Platform: info.Platform, Region: valueOrEmpty(info.Region)}, p)
+	}
+
+	if sources == nil {
+		sources = []db.Source{}
+	}
+	return StatsReport{
+		Total:     total.count(Count{}),
+		Platforms: platforms.counts(),
+		Services:  services.counts(),
+		Regions:   regions.counts(),
+		Sources:   sources,
+	}, nil
+}
+
+func valueOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// groups collects the prefixes of each group, keyed by a Count with only the
+// grouping fields set.
+type groups struct {
+	keys     []Count
+	prefixes map[Count][]netip.Prefix
+}
+
+func newGroups() *groups {
+	return &groups{prefixes: map[Count][]netip.Prefix{}}
+}
+
+func (g *groups) add(key Count, p netip.Prefix) {
+	if _, ok := g.prefixes[key]; !ok {
+		g.keys = append(g.keys, key)
+	}
+	g.prefixes[key] = append(g.prefixes[key], p)
+}
+
+func (g *groups) count(key Count) Count {
+	c := key
+	prefixes := g.prefixes[key]
+	for _, p := range prefixes {
+		c.Prefixes++
+		if p.Addr().Is4() {
+			c.IPv4Prefixes++
+		} else {
+			c.IPv6Prefixes++
+		}
+	}
+	for _, p := range cidr.Aggregate(prefixes) {
+		if p.Addr().Is4() {
+			c.IPv4Slash24s += math.Ldexp(1, 24-p.Bits())
+		} else {
+			c.IPv6Slash48s += math.Ldexp(1, 48-p.Bits())
+		}
+	}
+	return c
+}
+
+// counts returns a Count per group sorted by platform, service and region.
+func (g *groups) counts() []Count {
+	sort.Slice(g.keys, func(i, j int) bool {
+		a, b := g.keys[i], g.keys[j]
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.Region < b.Region
+	})
+	counts := make([]Count, len(g.keys))
+	for i, key := range g.keys {
+		counts[i] = g.count(key)
+	}
+	return counts
+}
@@ -0,0 +1,54 @@
+package report
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestStats(t *testing.T) {
+	infos := []db.PrefixInfo{
+		{Prefix: "10.0.0.0/24", Platform: "AWS", Service: stringPointer("AMAZON"), Region: stringPointer("us-east-1")},
+		{Prefix: "10.0.0.0/24", Platform: "AWS", Service: stringPointer("EC2"), Region: stringPointer("us-east-1")},
+		{Prefix: "10.0.1.0/25", Platform: "AWS", Service: stringPointer("EC2"), Region: stringPointer("us-west-2")},
+		{Prefix: "2001:db8::/47", Platform: "AWS", Service: stringPointer("EC2"), Region: stringPointer("us-west-2")},
+		{Prefix: "10.0.0.0/23", Platform: "CloudFlare"},
+	}
+	sources := []db.Source{
+		{URL: "https://ip-ranges.amazonaws.com/ip-ranges.json", Platform: "AWS", Prefixes: 4, UpdatedAt: time.Date(2024, 10, 8, 0, 0, 0, 0, time.UTC)},
+	}
+
+	got, err := Stats(infos, sources)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	want := StatsReport{
+		Total: Count{Prefixes: 5, IPv4Prefixes: 4, IPv6Prefixes: 1, IPv4Slash24s: 2, IPv6Slash48s: 2},
+		Platforms: []Count{
+			{Platform: "AWS", Prefixes: 4, IPv4Prefixes: 3, IPv6Prefixes: 1, IPv4Slash24s: 1.5, IPv6Slash48s: 2},
+			{Platform: "CloudFlare", Prefixes: 1, IPv4Prefixes: 1, IPv4Slash24s: 2},
+		},
+		Services: []Count{
+			{Platform: "AWS", Service: "AMAZON", Prefixes: 1, IPv4Prefixes: 1, IPv4Slash24s: 1},
+			{Platform: "AWS", Service: "EC2", Prefixes: 3, IPv4Prefixes: 2, IPv6Prefixes: 1, IPv4Slash24s: 1.5, IPv6Slash48s: 2},
+			{Platform: "CloudFlare", Prefixes: 1, IPv4Prefixes: 1, IPv4Slash24s: 2},
+		},
+		Regions: []Count{
+			{Platform: "AWS", Region: "us-east-1", Prefixes: 2, IPv4Prefixes: 2, IPv4Slash24s: 1},
+			{Platform: "AWS", Region: "us-west-2", Prefixes: 2, IPv4Prefixes: 1, IPv6Prefixes: 1, IPv4Slash24s: 0.5, IPv6Slash48s: 2},
+			{Platform: "CloudFlare", Prefixes: 1, IPv4Prefixes: 1, IPv4Slash24s: 2},
+		},
+		Sources: sources,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStats_InvalidCIDR(t *testing.T) {
+	if _, err := Stats([]db.PrefixInfo{{Prefix: "invalid_cidr", Platform: "AWS"}}, nil); err == nil {
+		t.Errorf("Stats() expected error for invalid CIDR")
+	}
+}
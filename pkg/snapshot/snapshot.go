@@ -0,0 +1,245 @@
+// Package snapshot serializes a set of prefixes into a compact binary blob and
+// embeds one in the binary so lookups work without a database.
+//
+// The embedded snapshot is regenerated from a populated database with
+//
+//	go generate ./pkg/snapshot
+//
+// which runs `cloudprefixes -update -snapshot pkg/snapshot/snapshot.bin`.
+package snapshot
+
+//go:generate go run github.com/mchaffe/cloudprefixes -dbpath ../../cloudprefixes.db -update -snapshot snapshot.bin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+//go:embed snapshot.bin
+var embedded []byte
+
+const (
+	magic   = "CPFX"
+	version = 2
+)
+
+// Embedded decodes the snapshot compiled into the binary. The result is empty
+// if the snapshot has not been generated.
+func Embedded() ([]db.PrefixInfo, error) {
+	if len(embedded) == 0 {
+		return []db.PrefixInfo{}, nil
+	}
+	return Decode(bytes.NewReader(embedded))
+}
+
+// Encode writes infos to w in the snapshot format.
+//
+// The format is the magic "CPFX" and a version byte followed by a gzip stream
+// holding a string table and the records. Each record stores the prefix as
+// address bytes and a length, and its platform, region, service, metadata and
+// category as indexes into the string table, with 0 standing for a nil value.
+// Metadata is stored as JSON. Version 1 snapshots, whose records have no
+// category, can still be decoded.
+func Encode(w io.Writer, infos []db.PrefixInfo) error {
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{version}); err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+
+	table := []string{}
+	index := map[string]uint64{}
+	intern := func(s *string) uint64 {
+		if s == nil {
+			return 0
+		}
+		i, ok := index[*s]
+		if !ok {
+			table = append(table, *s)
+			i = uint64(len(table))
+			index[*s] = i
+		}
+		return i
+	}
+
+	type record struct {
+		prefix                                        netip.Prefix
+		platform, region, service, metadata, category uint64
+	}
+	records := make([]record, 0, len(infos))
+	for _, info := range infos {
+		prefix, err := netip.ParsePrefix(info.Prefix)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %s: %v", info.Prefix, err)
+		}
+		platform := info.Platform
+		var metadata *string
+		if info.Metadata != nil {
+			b, err := json.Marshal(info.Metadata)
+			if err != nil {
+				return fmt.Errorf("invalid metadata for %s: %v", info.Prefix, err)
+			}
+			s := string(b)
+			metadata = &s
+		}
+		records = append(records, record{
+			prefix:   prefix,
+			platform: intern(&platform),
+			region:   intern(info.Region),
+			service:  intern(info.Service),
+			metadata: intern(metadata),
+			category: intern(info.Category),
+		})
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	putUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf, v)
+		bw.Write(buf[:n])
+	}
+
+	putUvarint(uint64(len(table)))
+	for _, s := range table {
+		putUvarint(uint64(len(s)))
+		bw.WriteString(s)
+	}
+
+	putUvarint(uint64(len(records)))
+	for _, r := range records {
+		addr, _ := r.prefix.Addr().MarshalBinary()
+		bw.WriteByte(byte(len(addr)))
+		bw.Write(addr)
+		bw.WriteByte(byte(r.prefix.Bits()))
+		putUvarint(r.platform)
+		putUvarint(r.region)
+		putUvarint(r.service)
+		putUvarint(r.metadata)
+		putUvarint(r.category)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Decode reads a snapshot written by Encode.
+func Decode(r io.Reader) ([]db.PrefixInfo, error) {
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("error reading snapshot header: %v", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, errors.New("not a cloudprefixes snapshot")
+	}
+	v := header[len(magic)]
+	if v != 1 && v != version {
+		return nil, fmt.Errorf("unsupported snapshot version %d", v)
+	}
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot: %v", err)
+	}
+	defer zr.Close()
+	br := bufio.NewReader(zr)
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("error reading string table: %v", err)
+	}
+	table := make([]string, count)
+	for i := range table {
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, fmt.Errorf("error reading string table: %v", err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, fmt.Errorf("error reading string table: %v", err)
+		}
+		table[i] = string(b)
+	}
+	str := func(i uint64) (*string, error) {
+		if i == 0 {
+			return nil, nil
+		}
+		if i > uint64(len(table)) {
+			return nil, fmt.Errorf("string index %d out of range", i)
+		}
+		return &table[i-1], nil
+	}
+
+	count, err = binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("error reading records: %v", err)
+	}
+	infos := make([]db.PrefixInfo, 0, count)
+	for i := uint64(0); i < count; i++ {
+		size, err := br.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("error reading record %d: %v", i, err)
+		}
+		b := make([]byte, int(size)+1)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, fmt.Errorf("error reading record %d: %v", i, err)
+		}
+		var addr netip.Addr
+		if err := addr.UnmarshalBinary(b[:size]); err != nil {
+			return nil, fmt.Errorf("error reading record %d: %v", i, err)
+		}
+		prefix := netip.PrefixFrom(addr, int(b[size]))
+		if !prefix.IsValid() {
+			return nil, fmt.Errorf("error reading record %d: invalid prefix", i)
+		}
+
+		var fields [5]*string
+		n := len(fields)
+		if v == 1 {
+			n = 4
+		}
+		for j := 0; j < n; j++ {
+			idx, err := binary.ReadUvarint(br)
+			if err != nil {
+				return nil, fmt.Errorf("error reading record %d: %v", i, err)
+			}
+			if fields[j], err = str(idx); err != nil {
+				return nil, fmt.Errorf("error reading record %d: %v", i, err)
+			}
+		}
+		if fields[0] == nil {
+			return nil, fmt.Errorf("error reading record %d: missing platform", i)
+		}
+
+		var metadata db.Metadata
+		if fields[3] != nil {
+			if err := json.Unmarshal([]byte(*fields[3]), &metadata); err != nil {
+				return nil, fmt.Errorf("error reading record %d: invalid metadata: %v", i, err)
+			}
+		}
+
+		infos = append(infos, db.PrefixInfo{
+			Prefix:   prefix.String(),
+			Platform: *fields[0],
+			Region:   fields[1],
+			Service:  fields[2],
+			Category: fields[4],
+			Metadata: metadata,
+		})
+	}
+	return infos, nil
+}
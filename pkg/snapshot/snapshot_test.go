@@ -0,0 +1,104 @@
+package snapshot
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		infos []db.PrefixInfo
+	}{
+		{"Empty", []db.PrefixInfo{}},
+		{
+			"Mixed",
+			[]db.PrefixInfo{
+				{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Hooks")},
+				{Prefix: "192.30.252.0/22", Platform: "GitHub", Service: stringPointer("Web")},
+				{
+					Prefix:   "2600:1f13::/36",
+					Platform: "AWS",
+					Region:   stringPointer("us-west-2"),
+					Service:  stringPointer("EC2"),
+					Metadata: db.Metadata{"network_border_group": "us-west-2"},
+				},
+				{Prefix: "45.55.32.0/19", Platform: "Digital Ocean"},
+				{Prefix: "66.249.64.0/27", Platform: "Google", Service: stringPointer("Googlebot"), Category: stringPointer("bot")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Encode(&buf, tt.infos); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			got, err := Decode(&buf)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.infos) {
+				t.Errorf("Decode() = %v, want %v", got, tt.infos)
+			}
+		})
+	}
+}
+
+func TestEncode_InvalidCIDR(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, []db.PrefixInfo{{Prefix: "invalid_cidr", Platform: "AWS"}}); err == nil {
+		t.Errorf("Encode() expected error for invalid CIDR")
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Empty", []byte{}},
+		{"Bad magic", []byte("NOPE\x01")},
+		{"Bad version", []byte("CPFX\x09")},
+		{"Truncated", []byte("CPFX\x01\x1f\x8b")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode(bytes.NewReader(tt.data)); err == nil {
+				t.Errorf("Decode() expected error")
+			}
+		})
+	}
+}
+
+func TestDecode_Version1(t *testing.T) {
+	// a version 1 snapshot of 10.0.0.0/8 on platform "AWS" with service "EC2"
+	var buf bytes.Buffer
+	buf.WriteString("CPFX\x01")
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("\x02\x03AWS\x03EC2\x01\x04\x0a\x00\x00\x00\x08\x01\x00\x02\x00"))
+	zw.Close()
+
+	got, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := []db.PrefixInfo{{Prefix: "10.0.0.0/8", Platform: "AWS", Service: stringPointer("EC2")}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %v, want %v", got, want)
+	}
+}
+
+func TestEmbedded(t *testing.T) {
+	if _, err := Embedded(); err != nil {
+		t.Errorf("Embedded() error = %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/mrt"
+)
+
+// ParseASN parses an AS number written as 24940 or AS24940.
+func ParseASN(s string) (uint32, error) {
+	s = strings.TrimSpace(s)
+	if len(s) > 2 && strings.EqualFold(s[:2], "AS") {
+		s = s[2:]
+	}
+	asn, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid AS number %q", s)
+	}
+	return uint32(asn), nil
+}
+
+// originRoute is a prefix originated by an AS.
+type originRoute struct {
+	prefix netip.Prefix
+	asn    uint32
+}
+
+// openCompressed opens path, transparently decompressing gzip and bzip2 files
+// as RIB dumps and pfx2as files are usually published compressed.
+func openCompressed(path string) (*bufio.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(3)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("error reading gzip file %s: %v", path, err)
+		}
+		return bufio.NewReader(zr), f, nil
+	case bytes.Equal(magic, []byte("BZh")):
+		return bufio.NewReader(bzip2.NewReader(br)), f, nil
+	}
+	return br, f, nil
+}
+
+// isText reports whether the start of a file looks like text rather than an
+// MRT header, whose record type always starts with a zero byte.
+func isText(b []byte) bool {
+	for _, c := range b {
+		if c < '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// readMRTOrigins returns the routes in an MRT RIB dump originated by one of
+// asns, and the time the dump was made.
+func readMRTOrigins(ctx context.Context, r io.Reader, asns map[uint32]bool) ([]originRoute, time.Time, error) {
+	var routes []originRoute
+	var dumped time.Time
+	seen := map[originRoute]bool{}
+	reader := mrt.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, dumped, err
+		}
+		rib, err := reader.Next()
+		if err == io.EOF {
+			return routes, dumped, nil
+		}
+		if err != nil {
+			return nil, dumped, err
+		}
+		if dumped.IsZero() {
+			dumped = rib.Time
+		}
+		for _, asn := range rib.Origins {
+			route := originRoute{rib.Prefix, asn}
+			if asns[asn] && !seen[route] {
+				seen[route] = true
+				routes = append(routes, route)
+			}
+		}
+	}
+}
+
+// readPfx2asOrigins returns the routes in a pfx2as file originated by one of
+// asns. Each line holds an address, a prefix length and the origin, separated
+// by whitespace, as published by CAIDA. Multiple origins are joined by "_"
+// and the members of an AS set by ",".
+func readPfx2asOrigins(ctx context.Context, r io.Reader, asns map[uint32]bool) ([]originRoute, error) {
+	var routes []originRoute
+	seen := map[originRoute]bool{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected address, length and origin", line)
+		}
+		prefix, err := netip.ParsePrefix(fields[0] + "/" + fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		for _, s := range strings.FieldsFunc(fields[2], func(r rune) bool { return r == '_' || r == ',' }) {
+			asn, err := ParseASN(s)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			route := originRoute{prefix.Masked(), asn}
+			if asns[asn] && !seen[route] {
+				seen[route] = true
+				routes = append(routes, route)
+			}
+		}
+	}
+	return routes, scanner.Err()
+}
+
+func (m *UpdateManager) ImportASNPrefixes(path string, platform string, asns []uint32) error {
+	return m.ImportASNPrefixesContext(context.Background(), path, platform, asns)
+}
+
+// ImportASNPrefixesContext stores the prefixes originated by any of asns in a
+// locally supplied BGP table under platform, for providers that don't publish
+// their prefixes. path is either an MRT TABLE_DUMP_V2 RIB dump, such as those
+// of RouteViews or RIPE RIS, or a pfx2as text file, and may be compressed with
+// gzip or bzip2. Each prefix has its origin AS as the service, e.g. AS24940,
+// and as asn metadata, and path as source metadata. The prefixes replace
+// those of an earlier import of path for platform, and the import is recorded
+// so UpdateAllSources applies it again.
+func (m *UpdateManager) ImportASNPrefixesContext(ctx context.Context, path string, platform string, asns []uint32) error {
+	if len(asns) == 0 {
+		return fmt.Errorf("no AS numbers given for %s", platform)
+	}
+	// updates may run from another directory
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	err = m.importASN(ctx, path, platform, asns)
+	if err != nil {
+		return err
+	}
+	err = m.PrefixManager.SetASNImportContext(ctx, db.ASNImport{Path: path, Platform: platform, ASNs: asns})
+	if err != nil {
+		return fmt.Errorf("error recording import of %s: %v", path, err)
+	}
+	return nil
+}
+
+// importASN stores the prefixes of the BGP table at the absolute path as
+// ImportASNPrefixesContext does, without recording the import.
+func (m *UpdateManager) importASN(ctx context.Context, path string, platform string, asns []uint32) error {
+	wanted := map[uint32]bool{}
+	for _, asn := range asns {
+		wanted[asn] = true
+	}
+
+	r, closer, err := openCompressed(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	source := db.Source{URL: path, Platform: platform}
+	var routes []originRoute
+	if header, _ := r.Peek(12); isText(header) {
+		routes, err = readPfx2asOrigins(ctx, r, wanted)
+	} else {
+		var dumped time.Time
+		routes, dumped, err = readMRTOrigins(ctx, r, wanted)
+		if !dumped.IsZero() {
+			published := dumped.Format(time.RFC3339)
+			source.Published = &published
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+
+	var prefixes []db.PrefixInfo
+	for _, route := range routes {
+		service := fmt.Sprintf("AS%d", route.asn)
+		prefixes = append(prefixes, db.PrefixInfo{
+			Platform: platform,
+			Service:  &service,
+			Prefix:   route.prefix.String(),
+			Metadata: db.Metadata{"asn": route.asn, "source": path},
+		})
+	}
+
+	err = m.PrefixManager.ReplaceASNPrefixesContext(ctx, path, platform, prefixes)
+	if err != nil {
+		return fmt.Errorf("error inserting data %v", err)
+	}
+	slog.Info("successfully inserted prefixes", "count", len(prefixes))
+	return m.recordSource(ctx, source, len(prefixes))
+}
@@ -0,0 +1,184 @@
+package update
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestParseASN(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint32
+		wantErr bool
+	}{
+		{"24940", 24940, false},
+		{"AS24940", 24940, false},
+		{" as16276 ", 16276, false},
+		{"4200000000", 4200000000, false},
+		{"AS", 0, true},
+		{"4294967296", 0, true},
+		{"hetzner", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseASN(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseASN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseASN() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateManager_ImportASNPrefixes(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		want      []string
+		published *string
+	}{
+		{"pfx2as gzip", "testdata/pfx2as.txt.gz", []string{"5.9.0.0/16", "103.21.244.0/22", "2a01:4f8::/29"}, nil},
+		{"MRT bzip2", "testdata/rib.mrt.bz2", []string{"5.9.0.0/16", "2a01:4f8::/29"}, stringPointer("2024-10-01T00:00:00Z")},
+		{"MRT", "testdata/rib.mrt", []string{"5.9.0.0/16", "2a01:4f8::/29"}, stringPointer("2024-10-01T00:00:00Z")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, _, cleanup := SetupUpdateManager()
+			defer cleanup()
+
+			if err := manager.ImportASNPrefixes(tt.path, "Hetzner", []uint32{24940}); err != nil {
+				t.Fatalf("UpdateManager.ImportASNPrefixes() error = %v", err)
+			}
+			infos, err := manager.PrefixManager.ListPrefixes(db.Filter{})
+			if err != nil {
+				t.Fatalf("failed to list prefixes: %v", err)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Prefix)
+				if info.Platform != "Hetzner" || *info.Service != "AS24940" || info.Metadata["asn"] != float64(24940) || !filepath.IsAbs(info.Metadata["source"].(string)) {
+					t.Errorf("UpdateManager.ImportASNPrefixes() stored %+v", info)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpdateManager.ImportASNPrefixes() stored %v, want %v", got, tt.want)
+			}
+
+			sources, err := manager.PrefixManager.ListSources()
+			if err != nil {
+				t.Fatalf("failed to list sources: %v", err)
+			}
+			if len(sources) != 1 || !reflect.DeepEqual(sources[0].Published, tt.published) {
+				t.Errorf("ListSources() = %+v, want published %v", sources, tt.published)
+			}
+		})
+	}
+}
+
+func TestUpdateManager_ImportASNPrefixes_Invalid(t *testing.T) {
+	manager, _, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.ImportASNPrefixes("testdata/rib.mrt", "Hetzner", nil); err == nil {
+		t.Errorf("UpdateManager.ImportASNPrefixes() expected error without AS numbers")
+	}
+	if err := manager.ImportASNPrefixes("testdata/missing.mrt", "Hetzner", []uint32{24940}); err == nil {
+		t.Errorf("UpdateManager.ImportASNPrefixes() expected error for missing file")
+	}
+	if err := manager.ImportASNPrefixes("testdata/aws_response.json", "Hetzner", []uint32{24940}); err == nil {
+		t.Errorf("UpdateManager.ImportASNPrefixes() expected error for a file of another format")
+	}
+}
+
+func TestUpdateManager_reimportASNs(t *testing.T) {
+	manager, _, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.ImportASNPrefixes("testdata/rib.mrt", "Hetzner", []uint32{24940}); err != nil {
+		t.Fatalf("UpdateManager.ImportASNPrefixes() error = %v", err)
+	}
+	// an import whose file has gone is skipped
+	if err := manager.PrefixManager.SetASNImport(db.ASNImport{Path: "/missing/rib.mrt", Platform: "OVH", ASNs: []uint32{16276}}); err != nil {
+		t.Fatalf("failed to record import: %v", err)
+	}
+	imports, err := manager.PrefixManager.ListASNImports()
+	if err != nil {
+		t.Fatalf("failed to list imports: %v", err)
+	}
+	if len(imports) != 2 || !filepath.IsAbs(imports[0].Path) {
+		t.Errorf("ListASNImports() = %+v, want both imports with absolute paths", imports)
+	}
+	if err := manager.PrefixManager.ClearAllData(); err != nil {
+		t.Fatalf("failed to clear database: %v", err)
+	}
+
+	if err := manager.reimportASNs(context.Background(), imports); err != nil {
+		t.Fatalf("UpdateManager.reimportASNs() error = %v", err)
+	}
+	infos, err := manager.PrefixManager.ListPrefixes(db.Filter{Platforms: []string{"Hetzner"}})
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("UpdateManager.reimportASNs() stored %d prefixes, want 2", len(infos))
+	}
+}
+
+func TestUpdateManager_ImportASNPrefixes_Refreshed(t *testing.T) {
+	manager, _, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	path := filepath.Join(t.TempDir(), "table")
+	copyFile(t, "testdata/pfx2as.txt.gz", path)
+	if err := manager.ImportASNPrefixes(path, "Hetzner", []uint32{24940}); err != nil {
+		t.Fatalf("UpdateManager.ImportASNPrefixes() error = %v", err)
+	}
+	// a prefix of another import is kept
+	if err := manager.ImportASNPrefixes("testdata/rib.mrt", "OVH", []uint32{24940}); err != nil {
+		t.Fatalf("UpdateManager.ImportASNPrefixes() error = %v", err)
+	}
+
+	// 103.21.244.0/22 is no longer originated
+	copyFile(t, "testdata/rib.mrt", path)
+	if err := manager.ImportASNPrefixes(path, "Hetzner", []uint32{24940}); err != nil {
+		t.Fatalf("UpdateManager.ImportASNPrefixes() error = %v", err)
+	}
+	if got := countPrefixes(t, manager, "Hetzner"); got != 2 {
+		t.Errorf("UpdateManager.ImportASNPrefixes() left %d prefixes, want 2", got)
+	}
+
+	if err := manager.PrefixManager.DeleteASNImport(path, "Hetzner"); err != nil {
+		t.Fatalf("PrefixManager.DeleteASNImport() error = %v", err)
+	}
+	if got := countPrefixes(t, manager, "Hetzner"); got != 0 {
+		t.Errorf("PrefixManager.DeleteASNImport() left %d prefixes, want 0", got)
+	}
+	if got := countPrefixes(t, manager, "OVH"); got != 2 {
+		t.Errorf("PrefixManager.DeleteASNImport() left %d prefixes of another import, want 2", got)
+	}
+}
+
+func copyFile(t *testing.T, src, dst string) {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", src, err)
+	}
+	if err := os.WriteFile(dst, b, 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", dst, err)
+	}
+}
+
+func countPrefixes(t *testing.T, manager *UpdateManager, platform string) int {
+	infos, err := manager.PrefixManager.ListPrefixes(db.Filter{Platforms: []string{platform}})
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	return len(infos)
+}
@@ -1,6 +1,7 @@
 package update
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
@@ -24,7 +25,11 @@ type AwsResponse struct {
 }
 
 func (m *UpdateManager) UpdateAwsPrefixes(url string) error {
-	body, err := GetJson(url)
+	return m.UpdateAwsPrefixesContext(context.Background(), url)
+}
+
+func (m *UpdateManager) UpdateAwsPrefixesContext(ctx context.Context, url string) error {
+	body, err := GetJsonContext(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -37,41 +42,28 @@ func (m *UpdateManager) UpdateAwsPrefixes(url string) error {
 
 	var prefixes []db.PrefixInfo
 	for _, prefix := range j.Prefixes {
-		metaMap := map[string]string{
-			"network_boarder_group": prefix.NetworkBorderGroup,
-		}
-		metaJSON, err := json.Marshal(metaMap)
-		if err != nil {
-			return err
-		}
-		metaStr := string(metaJSON)
-
 		prefixes = append(prefixes, db.PrefixInfo{
 			Platform: "AWS",
 			Region:   prefix.Region,
 			Service:  prefix.Service,
 			Prefix:   prefix.IPPrefix,
-			Metadata: &metaStr,
+			Metadata: db.Metadata{"network_border_group": prefix.NetworkBorderGroup},
 		})
 	}
 	for _, prefix := range j.Ipv6Prefixes {
-		metaMap := map[string]string{
-			"network_boarder_group": prefix.NetworkBorderGroup,
-		}
-		metaJSON, err := json.Marshal(metaMap)
-		if err != nil {
-			return err
-		}
-		metaStr := string(metaJSON)
-
 		prefixes = append(prefixes, db.PrefixInfo{
 			Platform: "AWS",
 			Region:   prefix.Region,
 			Service:  prefix.Service,
 			Prefix:   prefix.Ipv6Prefix,
-			Metadata: &metaStr,
+			Metadata: db.Metadata{"network_border_group": prefix.NetworkBorderGroup},
 		})
 	}
 
-	return m.InsertPrefixes(prefixes)
+	return m.insertSource(ctx, db.Source{
+		URL:       url,
+		Platform:  "AWS",
+		Published: stringOrNil(j.CreateDate),
+		SyncToken: stringOrNil(j.SyncToken),
+	}, prefixes)
 }
@@ -1,10 +1,12 @@
 package update
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"regexp"
+	"strconv"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
@@ -13,16 +15,16 @@ type AzureResponse struct {
 	ChangeNumber int    `json:"changeNumber"`
 	Cloud        string `json:"cloud"`
 	Values       []struct {
-		Name            string   `json:"name"`
-		ID              string   `json:"id"`
-		NetworkFeatures []string `json:"networkFeatures"`
-		Properties      struct {
+		Name       string `json:"name"`
+		ID         string `json:"id"`
+		Properties struct {
 			ChangeNumber    int      `json:"changeNumber"`
-			Region          *string  `json:"region"`
+			Region          string   `json:"region"`
 			RegionID        int      `json:"regionId"`
 			Platform        string   `json:"platform"`
-			SystemService   *string  `json:"systemService"`
+			SystemService   string   `json:"systemService"`
 			AddressPrefixes []string `json:"addressPrefixes"`
+			NetworkFeatures []string `json:"networkFeatures"`
 		} `json:"properties"`
 	} `json:"values"`
 }
@@ -44,14 +46,18 @@ func (f *MicrosoftURLFinder) FindURL(body []byte) (string, error) {
 }
 
 func (m *UpdateManager) UpdateAzurePrefixes(url string) error {
+	return m.UpdateAzurePrefixesContext(context.Background(), url)
+}
+
+func (m *UpdateManager) UpdateAzurePrefixesContext(ctx context.Context, url string) error {
 	slog.Info("fetching HTML to find JSON", "url", url)
-	jsonUrl, err := GetJsonUrl(url, &MicrosoftURLFinder{})
+	jsonUrl, err := m.getJsonUrl(ctx, url, &MicrosoftURLFinder{})
 	if err != nil {
 		return err
 	}
 
-	slog.Info("fetching JSON", "url", url)
-	body, err := GetJson(jsonUrl)
+	slog.Info("fetching JSON", "url", jsonUrl)
+	body, err := GetJsonContext(ctx, jsonUrl)
 	if err != nil {
 		return err
 	}
@@ -62,20 +68,37 @@ func (m *UpdateManager) UpdateAzurePrefixes(url string) error {
 		return err
 	}
 
+	// The service tag name, e.g. AzureCloud.eastus, is the service as tags
+	// are what Azure rules refer to, and several tags can share a system
+	// service and region.
 	var prefixes []db.PrefixInfo
 	for _, value := range j.Values {
-		region := value.Properties.Region
-		if region != nil {
-			*region = "global"
+		p := value.Properties
+		var region *string
+		if p.Region != "" {
+			region = &p.Region
 		}
-		for _, addressPrefix := range value.Properties.AddressPrefixes {
+		name := value.Name
+		for _, addressPrefix := range p.AddressPrefixes {
+			metadata := db.Metadata{
+				"cloud":         j.Cloud,
+				"region_id":     p.RegionID,
+				"change_number": p.ChangeNumber,
+			}
+			if p.SystemService != "" {
+				metadata["system_service"] = p.SystemService
+			}
+			if len(p.NetworkFeatures) > 0 {
+				metadata["network_features"] = p.NetworkFeatures
+			}
 			prefixes = append(prefixes, db.PrefixInfo{
 				Platform: "Azure",
 				Region:   region,
-				Service:  value.Properties.SystemService,
+				Service:  &name,
 				Prefix:   addressPrefix,
+				Metadata: metadata,
 			})
 		}
 	}
-	return m.InsertPrefixes(prefixes)
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "Azure", Published: stringOrNil(strconv.Itoa(j.ChangeNumber))}, prefixes)
 }
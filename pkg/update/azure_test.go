@@ -3,6 +3,7 @@ package update
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
@@ -96,8 +97,52 @@ func TestUpdateManager_UpdateAzurePrefixes(t *testing.T) {
 				t.Fatalf("failed to query prefixes: %v", err)
 			}
 
-			if !found && len(prefixes) != 4 {
-				t.Errorf("UpdateManager.UpdateAzurePrefixes() len = %d, wanted 4", len(prefixes))
+			if !found || len(prefixes) != 4 {
+				t.Fatalf("UpdateManager.UpdateAzurePrefixes() len = %d, wanted 4", len(prefixes))
+			}
+
+			want := map[string]db.PrefixInfo{
+				"ActionGroup": {
+					Prefix:   "13.69.109.132/30",
+					Platform: "Azure",
+					Service:  stringPointer("ActionGroup"),
+					Metadata: db.Metadata{"cloud": "Public", "region_id": 0.0, "change_number": 43.0, "system_service": "ActionGroup", "network_features": []any{"API", "NSG", "UDR", "FW"}},
+				},
+				"ActionGroup.WestEurope": {
+					Prefix:   "13.69.109.132/30",
+					Platform: "Azure",
+					Region:   stringPointer("westeurope"),
+					Service:  stringPointer("ActionGroup.WestEurope"),
+					Metadata: db.Metadata{"cloud": "Public", "region_id": 18.0, "change_number": 6.0, "system_service": "ActionGroup"},
+				},
+				"AzureCloud.westeurope": {
+					Prefix:   "13.69.0.0/17",
+					Platform: "Azure",
+					Region:   stringPointer("westeurope"),
+					Service:  stringPointer("AzureCloud.westeurope"),
+					Metadata: db.Metadata{"cloud": "Public", "region_id": 18.0, "change_number": 133.0, "network_features": []any{"API", "NSG"}},
+				},
+			}
+			for _, p := range prefixes {
+				w, ok := want[*p.Service]
+				if !ok {
+					continue
+				}
+				if !reflect.DeepEqual(p, w) {
+					t.Errorf("UpdateManager.UpdateAzurePrefixes() stored %+v, want %+v", p, w)
+				}
+				delete(want, *p.Service)
+			}
+			for name := range want {
+				t.Errorf("UpdateManager.UpdateAzurePrefixes() missing service tag %s", name)
+			}
+
+			sources, err := manager.PrefixManager.ListSources()
+			if err != nil {
+				t.Fatalf("failed to list sources: %v", err)
+			}
+			if len(sources) != 1 || sources[0].Published == nil || *sources[0].Published != "325" {
+				t.Errorf("UpdateManager.UpdateAzurePrefixes() recorded sources %+v, want change number 325", sources)
 			}
 		})
 	}
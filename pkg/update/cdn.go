@@ -0,0 +1,110 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// CDNService is the service of prefixes used by content delivery and edge
+// networks, so their traffic can be told apart from origin clouds regardless
+// of provider, e.g. with -service CDN.
+const CDNService = "CDN"
+
+// cdnPrefixes returns the prefixes of platform's edge network.
+func cdnPrefixes(platform string, cidrs ...[]string) []db.PrefixInfo {
+	service := CDNService
+	var prefixes []db.PrefixInfo
+	for _, list := range cidrs {
+		for _, cidr := range list {
+			prefixes = append(prefixes, db.PrefixInfo{
+				Platform: platform,
+				Prefix:   cidr,
+				Service:  &service,
+			})
+		}
+	}
+	return prefixes
+}
+
+type FastlyResponse struct {
+	Addresses     []string `json:"addresses"`
+	IPv6Addresses []string `json:"ipv6_addresses"`
+}
+
+func (m *UpdateManager) UpdateFastlyPrefixes(url string) error {
+	return m.UpdateFastlyPrefixesContext(context.Background(), url)
+}
+
+// UpdateFastlyPrefixesContext stores the Fastly public IP list at url, e.g.
+// https://api.fastly.com/public-ip-list
+func (m *UpdateManager) UpdateFastlyPrefixesContext(ctx context.Context, url string) error {
+	body, err := GetJsonContext(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	var j FastlyResponse
+	err = json.Unmarshal(body, &j)
+	if err != nil {
+		return err
+	}
+
+	prefixes := cdnPrefixes("Fastly", j.Addresses, j.IPv6Addresses)
+
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "Fastly"}, prefixes)
+}
+
+type CloudflareResponse struct {
+	Result struct {
+		IPv4CIDRs    []string `json:"ipv4_cidrs"`
+		IPv6CIDRs    []string `json:"ipv6_cidrs"`
+		JDCloudCIDRs []string `json:"jdcloud_cidrs"`
+		Etag         string   `json:"etag"`
+	} `json:"result"`
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (m *UpdateManager) UpdateCloudflarePrefixes(url string) error {
+	return m.UpdateCloudflarePrefixesContext(context.Background(), url)
+}
+
+// UpdateCloudflarePrefixesContext stores the prefixes from the Cloudflare IP
+// API at url, e.g. https://api.cloudflare.com/client/v4/ips. The prefixes of
+// the JD Cloud partner network in China, returned when url asks for
+// networks=jdcloud, are stored with jdcloud metadata. The response's etag is
+// recorded as the source's sync token.
+func (m *UpdateManager) UpdateCloudflarePrefixesContext(ctx context.Context, url string) error {
+	body, err := GetJsonContext(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	var j CloudflareResponse
+	err = json.Unmarshal(body, &j)
+	if err != nil {
+		return err
+	}
+	if !j.Success {
+		var messages []string
+		for _, e := range j.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare API error: %s", strings.Join(messages, ", "))
+	}
+
+	prefixes := cdnPrefixes("CloudFlare", j.Result.IPv4CIDRs, j.Result.IPv6CIDRs)
+	for _, p := range cdnPrefixes("CloudFlare", j.Result.JDCloudCIDRs) {
+		p.Metadata = db.Metadata{"network": "jdcloud"}
+		prefixes = append(prefixes, p)
+	}
+
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "CloudFlare", SyncToken: stringOrNil(j.Result.Etag)}, prefixes)
+}
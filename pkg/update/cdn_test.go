@@ -0,0 +1,66 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestUpdateManager_UpdateCDNPrefixes(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateFastlyPrefixes(ts.URL() + "/fastly_response.json"); err != nil {
+		t.Fatalf("UpdateManager.UpdateFastlyPrefixes() error = %v", err)
+	}
+	if err := manager.UpdateCloudflarePrefixes(ts.URL() + "/cloudflare_response.json"); err != nil {
+		t.Fatalf("UpdateManager.UpdateCloudflarePrefixes() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want []db.PrefixInfo
+	}{
+		{"Fastly IPv4", "151.101.1.69", []db.PrefixInfo{{Prefix: "151.101.0.0/16", Platform: "Fastly", Service: stringPointer(CDNService)}}},
+		{"Fastly IPv6", "2a04:4e42::1", []db.PrefixInfo{{Prefix: "2a04:4e42::/32", Platform: "Fastly", Service: stringPointer(CDNService)}}},
+		{"CloudFlare IPv4", "104.16.132.229", []db.PrefixInfo{{Prefix: "104.16.0.0/13", Platform: "CloudFlare", Service: stringPointer(CDNService)}}},
+		{"CloudFlare IPv6", "2606:4700::6810:84e5", []db.PrefixInfo{{Prefix: "2606:4700::/32", Platform: "CloudFlare", Service: stringPointer(CDNService)}}},
+		{
+			"CloudFlare JD Cloud",
+			"36.111.152.1",
+			[]db.PrefixInfo{{Prefix: "36.111.152.0/21", Platform: "CloudFlare", Service: stringPointer(CDNService), Metadata: db.Metadata{"network": "jdcloud"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ContainsIP(%s) = %+v, want %+v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Platform != "CloudFlare" || sources[0].Prefixes != 24 ||
+		sources[0].SyncToken == nil || *sources[0].SyncToken != "38f79d050aa027e3be3865e495dcc9bc" ||
+		sources[1].Platform != "Fastly" || sources[1].Prefixes != 21 {
+		t.Errorf("ListSources() = %+v, want CloudFlare and Fastly", sources)
+	}
+}
+
+func TestUpdateManager_UpdateCloudflarePrefixes_APIError(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateCloudflarePrefixes(ts.URL() + "/cloudflare_error.json"); err == nil {
+		t.Errorf("UpdateManager.UpdateCloudflarePrefixes() expected error for unsuccessful response")
+	}
+}
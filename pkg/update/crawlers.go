@@ -0,0 +1,64 @@
+package update
+
+import (
+	"context"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Crawler is a vendor published list of the prefixes one of its crawlers
+// uses, in the same format as GoogleResponse.
+type Crawler struct {
+	URL      string
+	Platform string
+	Service  string
+}
+
+// Crawlers are the crawler lists fetched by UpdateAllSources.
+var Crawlers = []Crawler{
+	{"https://developers.google.com/static/search/apis/ipranges/googlebot.json", "Google", "Googlebot"},
+	{"https://developers.google.com/static/search/apis/ipranges/special-crawlers.json", "Google", "Google special crawlers"},
+	{"https://developers.google.com/static/search/apis/ipranges/user-triggered-fetchers.json", "Google", "Google user-triggered fetchers"},
+	{"https://developers.google.com/static/search/apis/ipranges/user-triggered-fetchers-google.json", "Google", "Google user-triggered fetchers (Google)"},
+	{"https://www.bing.com/toolbox/bingbot.json", "Microsoft", "Bingbot"},
+	{"https://search.developer.apple.com/applebot.json", "Apple", "Applebot"},
+	{"https://openai.com/gptbot.json", "OpenAI", "GPTBot"},
+	{"https://openai.com/searchbot.json", "OpenAI", "OAI-SearchBot"},
+	{"https://openai.com/chatgpt-user.json", "OpenAI", "ChatGPT-User"},
+	{"https://www.perplexity.com/perplexitybot.json", "Perplexity", "PerplexityBot"},
+	{"https://www.perplexity.com/perplexity-user.json", "Perplexity", "Perplexity-User"},
+}
+
+func (m *UpdateManager) UpdateCrawlerPrefixes(url string, platform string, service string) error {
+	return m.UpdateCrawlerPrefixesContext(context.Background(), url, platform, service)
+}
+
+// UpdateCrawlerPrefixesContext stores the prefixes of the crawler list at url
+// with the db.BotCategory category.
+func (m *UpdateManager) UpdateCrawlerPrefixesContext(ctx context.Context, url string, platform string, service string) error {
+	j, err := getGoogle(ctx, url)
+	if err != nil {
+		return err
+	}
+	cidrs, err := j.prefixes()
+	if err != nil {
+		return err
+	}
+
+	category := db.BotCategory
+	var prefixes []db.PrefixInfo
+	for _, c := range cidrs {
+		prefixes = append(prefixes, db.PrefixInfo{
+			Platform: platform,
+			Service:  &service,
+			Category: &category,
+			Prefix:   c,
+		})
+	}
+
+	return m.insertSource(ctx, db.Source{
+		URL:       url,
+		Platform:  platform,
+		Published: stringOrNil(j.CreationTime),
+	}, prefixes)
+}
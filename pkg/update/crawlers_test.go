@@ -0,0 +1,69 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestUpdateManager_UpdateCrawlerPrefixes(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		url      string
+		platform string
+		service  string
+		ip       string
+		want     []db.PrefixInfo
+	}{
+		{
+			"Googlebot",
+			ts.URL() + "/googlebot_response.json",
+			"Google",
+			"Googlebot",
+			"66.249.64.33",
+			[]db.PrefixInfo{{Prefix: "66.249.64.32/27", Platform: "Google", Service: stringPointer("Googlebot"), Category: stringPointer(db.BotCategory)}},
+		},
+		{
+			"Bingbot",
+			ts.URL() + "/bingbot_response.json",
+			"Microsoft",
+			"Bingbot",
+			"157.55.39.1",
+			[]db.PrefixInfo{{Prefix: "157.55.39.0/24", Platform: "Microsoft", Service: stringPointer("Bingbot"), Category: stringPointer(db.BotCategory)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := manager.UpdateCrawlerPrefixes(tt.url, tt.platform, tt.service); err != nil {
+				t.Fatalf("UpdateManager.UpdateCrawlerPrefixes() error = %v", err)
+			}
+			_, got, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ContainsIP(%s) = %+v, want %+v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	bots, err := manager.PrefixManager.ListPrefixes(db.Filter{Categories: []string{db.BotCategory}})
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	if len(bots) != 7 {
+		t.Errorf("ListPrefixes() len = %d, want 7", len(bots))
+	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 2 || sources[0].Published == nil || *sources[0].Published != "2024-09-27T15:46:03.000000" {
+		t.Errorf("ListSources() = %+v, want the Googlebot creation time", sources)
+	}
+}
@@ -1,11 +1,15 @@
 package update
 
 import (
+	"context"
 	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net/http"
+	"log/slog"
+	"net/netip"
+	"regexp"
+	"strings"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
@@ -18,6 +22,23 @@ type Geofeed struct {
 	Postal      *string `json:"postal,omitempty"`
 }
 
+// metadata returns the location's fields that are set, keyed by their JSON
+// names.
+func (g Geofeed) metadata() map[string]any {
+	m := map[string]any{}
+	for k, v := range map[string]*string{
+		"country_code": g.CountryCode,
+		"region_code":  g.RegionCode,
+		"city":         g.City,
+		"postal":       g.Postal,
+	} {
+		if v != nil {
+			m[k] = *v
+		}
+	}
+	return m
+}
+
 // Helper function to handle optional string fields in the CSV
 func optionalString(record []string, index int) *string {
 	if len(record) > index && record[index] != "" {
@@ -26,57 +47,199 @@ func optionalString(record []string, index int) *string {
 	return nil
 }
 
-func (m *UpdateManager) UpdateGeoFeedPrefixes(url string, platform string) error {
-	res, err := http.Get(url)
-	if err != nil {
-		return err
+// countryCodes are the ISO 3166-1 alpha-2 codes, and XK for Kosovo, which is
+// user assigned but widely used in geofeeds.
+var countryCodes = map[string]bool{}
+
+func init() {
+	for _, c := range strings.Fields(`
+		AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ
+		BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+		CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ
+		DE DJ DK DM DO DZ
+		EC EE EG EH ER ES ET
+		FI FJ FK FM FO FR
+		GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY
+		HK HM HN HR HT HU
+		ID IE IL IM IN IO IQ IR IS IT
+		JE JM JO JP
+		KE KG KH KI KM KN KP KR KW KY KZ
+		LA LB LC LI LK LR LS LT LU LV LY
+		MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ
+		NA NC NE NF NG NI NL NO NP NR NU NZ
+		OM
+		PA PE PF PG PH PK PL PM PN PR PS PT PW PY
+		QA
+		RE RO RS RU RW
+		SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ
+		TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ
+		UA UG UM US UY UZ
+		VA VC VE VG VI VN VU
+		WF WS
+		XK
+		YE YT
+		ZA ZM ZW`) {
+		countryCodes[c] = true
 	}
-	defer res.Body.Close()
+}
+
+// subdivisionCode matches the form of an ISO 3166-2 code, the country code
+// followed by up to three letters or digits.
+var subdivisionCode = regexp.MustCompile(`^([A-Z]{2})-[A-Z0-9]{1,3}$`)
+
+// GeofeedWarning is a geofeed line that was skipped, or whose location was
+// only partly stored, because it didn't follow RFC 8805.
+type GeofeedWarning struct {
+	Line   int
+	Reason string
+}
+
+func (w GeofeedWarning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Reason)
+}
 
-	if res.StatusCode != 200 {
-		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+// parseGeofeedRecord validates a geofeed record of prefix, country code,
+// subdivision code, city and postal code. An invalid prefix is an error,
+// while an invalid country or subdivision code is dropped from the location
+// with a warning. Codes are normalised to upper case.
+func parseGeofeedRecord(record []string) (Geofeed, []string, error) {
+	for i := range record {
+		record[i] = strings.TrimSpace(record[i])
+	}
+	prefix, err := netip.ParsePrefix(record[0])
+	if err != nil {
+		return Geofeed{}, nil, fmt.Errorf("invalid prefix %q", record[0])
+	}
+	var warnings []string
+	if prefix != prefix.Masked() {
+		warnings = append(warnings, fmt.Sprintf("prefix %s has host bits set", prefix))
+		prefix = prefix.Masked()
 	}
 
-	var prefixes []db.PrefixInfo
+	location := Geofeed{
+		Prefix:      prefix.String(),
+		CountryCode: optionalString(record, 1),
+		RegionCode:  optionalString(record, 2),
+		City:        optionalString(record, 3),
+		Postal:      optionalString(record, 4),
+	}
+	if location.CountryCode != nil {
+		country := strings.ToUpper(*location.CountryCode)
+		location.CountryCode = &country
+		if !countryCodes[country] {
+			warnings = append(warnings, fmt.Sprintf("invalid country code %q", country))
+			location.CountryCode = nil
+		}
+	}
+	if location.RegionCode != nil {
+		region := strings.ToUpper(*location.RegionCode)
+		location.RegionCode = &region
+		match := subdivisionCode.FindStringSubmatch(region)
+		switch {
+		case match == nil:
+			warnings = append(warnings, fmt.Sprintf("invalid subdivision code %q", region))
+			location.RegionCode = nil
+		case location.CountryCode != nil && match[1] != *location.CountryCode:
+			warnings = append(warnings, fmt.Sprintf("subdivision code %q is not in country %s", region, *location.CountryCode))
+			location.RegionCode = nil
+		}
+	}
+	return location, warnings, nil
+}
 
-	reader := csv.NewReader(res.Body)
+// readGeofeed reads an RFC 8805 geofeed from r, calling fn with each valid
+// entry. Lines starting with # are comments. Lines that can't be parsed are
+// skipped and passed to warn rather than failing the whole feed, which is
+// often hand maintained.
+func readGeofeed(r io.Reader, fn func(Geofeed) error, warn func(GeofeedWarning)) error {
+	reader := csv.NewReader(r)
 	reader.Comma = ','
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
 
 	for {
 		record, err := reader.Read()
 		if err == io.EOF {
-			break
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			warn(GeofeedWarning{parseErr.Line, parseErr.Err.Error()})
+			continue
 		}
 		if err != nil {
 			return fmt.Errorf("error reading CSV: %v", err)
 		}
+		line, _ := reader.FieldPos(0)
 
-		if len(record) == 0 {
+		if len(record) == 0 || (len(record) == 1 && strings.TrimSpace(record[0]) == "") {
 			continue
 		}
 
-		location := Geofeed{
-			CountryCode: optionalString(record, 1),
-			RegionCode:  optionalString(record, 2),
-			City:        optionalString(record, 3),
-			Postal:      optionalString(record, 4),
+		location, reasons, err := parseGeofeedRecord(record)
+		if err != nil {
+			warn(GeofeedWarning{line, err.Error()})
+			continue
 		}
-
-		metaMap := map[string]Geofeed{
-			"location": location,
+		for _, reason := range reasons {
+			warn(GeofeedWarning{line, reason})
 		}
-		metaJSON, err := json.Marshal(metaMap)
-		if err != nil {
+
+		if err := fn(location); err != nil {
 			return err
 		}
-		metaStr := string(metaJSON)
+	}
+}
 
-		prefixes = append(prefixes, db.PrefixInfo{
-			Prefix:   record[0],
-			Platform: platform,
-			Metadata: &metaStr,
-		})
+// maxGeofeedWarnings limits the warnings logged for each feed, so a feed that
+// is broken throughout doesn't flood the log.
+const maxGeofeedWarnings = 10
+
+func (m *UpdateManager) UpdateGeoFeedPrefixes(url string, platform string) error {
+	return m.UpdateGeoFeedPrefixesContext(context.Background(), url, platform)
+}
+
+// UpdateGeoFeedPrefixesContext stores the prefixes of the geofeed at url under
+// platform, with the location of each as metadata. The feed is read as a
+// stream, as some, like Apple's iCloud Private Relay egress ranges, are too
+// large to hold in memory. Lines that don't follow RFC 8805 are skipped, or
+// stored without their invalid codes, and logged as warnings.
+func (m *UpdateManager) UpdateGeoFeedPrefixesContext(ctx context.Context, url string, platform string) error {
+	return m.updateGeoFeed(ctx, url, platform, "")
+}
+
+// updateGeoFeed is UpdateGeoFeedPrefixesContext storing the prefixes with
+// category, unless it is empty.
+func (m *UpdateManager) updateGeoFeed(ctx context.Context, url string, platform string, category string) error {
+	body, err := openURL(ctx, url)
+	if err != nil {
+		return err
 	}
+	defer body.Close()
+
+	return m.insertSourceStream(ctx, func(insert func(db.PrefixInfo) error) (db.Source, error) {
+		source := db.Source{URL: url, Platform: platform}
 
-	return m.InsertPrefixes(prefixes)
+		warnings := 0
+		err := readGeofeed(body, func(location Geofeed) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return insert(db.PrefixInfo{
+				Prefix:   location.Prefix,
+				Platform: platform,
+				Category: stringOrNil(category),
+				Metadata: db.Metadata{"location": location.metadata()},
+			})
+		}, func(w GeofeedWarning) {
+			if warnings < maxGeofeedWarnings {
+				slog.Warn("geofeed warning", "url", url, "line", w.Line, "reason", w.Reason)
+			}
+			warnings++
+		})
+		if warnings > 0 {
+			slog.Warn("geofeed entries with warnings", "url", url, "count", warnings)
+		}
+		return source, err
+	})
 }
@@ -1,8 +1,12 @@
 package update
 
 import (
+	"context"
+	"os"
 	"reflect"
 	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
 
 func Test_optionalString(t *testing.T) {
@@ -51,6 +55,119 @@ func TestUpdateManager_UpdateGeoFeedPrefixes(t *testing.T) {
 			if !found && len(prefixes) != 1 {
 				t.Errorf("UpdateManager.UpdateAzurePrefixes() len = %d, wanted 1", len(prefixes))
 			}
+
+			_, prefixes, err = manager.PrefixManager.ContainsIP("5.101.96.1")
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			want := db.Metadata{"location": map[string]any{"country_code": "NL", "region_code": "NL-NH", "city": "Amsterdam", "postal": "1098 XH"}}
+			if len(prefixes) != 1 || !reflect.DeepEqual(prefixes[0].Metadata, want) {
+				t.Errorf("UpdateManager.UpdateGeoFeedPrefixes() stored %+v, want metadata %v", prefixes, want)
+			}
+		})
+	}
+}
+
+func TestUpdateManager_UpdateGeoFeedPrefixes_PrivateRelay(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	platform := "Apple iCloud Private Relay"
+	if err := manager.updateGeoFeed(context.Background(), ts.URL()+"/private_relay_response.csv", platform, db.AnonymizerCategory); err != nil {
+		t.Fatalf("UpdateManager.updateGeoFeed() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want []db.PrefixInfo
+	}{
+		{
+			"172.224.224.3",
+			[]db.PrefixInfo{{Prefix: "172.224.224.2/31", Platform: platform, Category: stringPointer(db.AnonymizerCategory), Metadata: db.Metadata{"location": map[string]any{"country_code": "GB", "region_code": "GB-EN", "city": "London"}}}},
+		},
+		{
+			"2a09:bac0:1000:10::1",
+			[]db.PrefixInfo{{Prefix: "2a09:bac0:1000:10::/64", Platform: platform, Category: stringPointer(db.AnonymizerCategory), Metadata: db.Metadata{"location": map[string]any{"country_code": "JP", "region_code": "JP-13", "city": "Tokyo"}}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			_, got, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ContainsIP(%s) = %+v, want %+v", tt.ip, got, tt.want)
+			}
 		})
 	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Platform != platform || sources[0].Prefixes != 6 {
+		t.Errorf("ListSources() = %+v, want 6 prefixes for %s", sources, platform)
+	}
+}
+
+func Test_readGeofeed(t *testing.T) {
+	f, err := os.Open("testdata/geofeed_invalid.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []db.Metadata
+	var prefixes []string
+	var warnings []GeofeedWarning
+	err = readGeofeed(f, func(location Geofeed) error {
+		prefixes = append(prefixes, location.Prefix)
+		got = append(got, db.Metadata(location.metadata()))
+		return nil
+	}, func(w GeofeedWarning) {
+		warnings = append(warnings, w)
+	})
+	if err != nil {
+		t.Fatalf("readGeofeed() error = %v", err)
+	}
+
+	wantPrefixes := []string{"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32"}
+	if !reflect.DeepEqual(prefixes, wantPrefixes) {
+		t.Errorf("readGeofeed() prefixes = %v, want %v", prefixes, wantPrefixes)
+	}
+	want := []db.Metadata{
+		{"country_code": "US", "region_code": "US-CA", "city": "Los Angeles", "postal": "90012"},
+		{"country_code": "GB", "region_code": "GB-LND", "city": "London"},
+		{"region_code": "GB-LND", "city": "London"},
+		{"country_code": "DE", "city": "Berlin"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readGeofeed() locations = %v, want %v", got, want)
+	}
+
+	var lines []int
+	for _, w := range warnings {
+		lines = append(lines, w.Line)
+	}
+	wantLines := []int{2, 4, 5, 6, 8, 9}
+	if !reflect.DeepEqual(lines, wantLines) {
+		t.Errorf("readGeofeed() warnings = %v, want lines %v", warnings, wantLines)
+	}
+}
+
+func TestUpdateManager_UpdateGeoFeedPrefixes_Invalid(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateGeoFeedPrefixes(ts.URL()+"/geofeed_invalid.csv", "Example"); err != nil {
+		t.Fatalf("UpdateManager.UpdateGeoFeedPrefixes() error = %v", err)
+	}
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Prefixes != 4 {
+		t.Errorf("ListSources() = %+v, want 4 prefixes", sources)
+	}
 }
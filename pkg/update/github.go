@@ -1,81 +1,142 @@
 package update
 
 import (
+	"context"
 	"encoding/json"
-	"net"
-	"reflect"
+	"fmt"
+	"net/netip"
+	"sort"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
 
 	"log/slog"
 )
 
-type GithubResponse struct {
-	VerifiablePasswordAuthentication bool `json:"verifiable_password_authentication"`
-	SSHKeyFingerprints               struct {
-		Sha256Ecdsa   string `json:"SHA256_ECDSA"`
-		Sha256Ed25519 string `json:"SHA256_ED25519"`
-		Sha256Rsa     string `json:"SHA256_RSA"`
-	} `json:"ssh_key_fingerprints"`
-	SSHKeys                  []string `json:"ssh_keys"`
-	Hooks                    []string `json:"hooks"`
-	Web                      []string `json:"web"`
-	API                      []string `json:"api"`
-	Git                      []string `json:"git"`
-	GithubEnterpriseImporter []string `json:"github_enterprise_importer"`
-	Packages                 []string `json:"packages"`
-	Pages                    []string `json:"pages"`
-	Importer                 []string `json:"importer"`
-	Actions                  []string `json:"actions"`
-	ActionsMacos             []string `json:"actions_macos"`
-	Codespaces               []string `json:"codespaces"`
-	Dependabot               []string `json:"dependabot"`
-	Copilot                  []string `json:"copilot"`
-	Domains                  struct {
-		Website              []string `json:"website"`
-		Codespaces           []string `json:"codespaces"`
-		Copilot              []string `json:"copilot"`
-		Packages             []string `json:"packages"`
-		Actions              []string `json:"actions"`
-		ArtifactAttestations struct {
-			TrustDomain string   `json:"trust_domain"`
-			Services    []string `json:"services"`
-		} `json:"artifact_attestations"`
-	} `json:"domains"`
+// GithubResponse is the GitHub meta API response. It is decoded generically
+// so services GitHub adds later are picked up without code changes: every key
+// holding a list of CIDRs, such as "hooks" or "actions_macos", is stored as a
+// service of that name.
+type GithubResponse map[string]json.RawMessage
+
+// githubPrefixes returns the prefixes of every key whose value is a list of
+// CIDRs. Lists of anything else, such as ssh_keys, are skipped. The git
+// prefixes carry the SSH host key fingerprints, as git over SSH is served
+// from them.
+func githubPrefixes(meta GithubResponse) ([]db.PrefixInfo, error) {
+	var fingerprints map[string]string
+	if raw, ok := meta["ssh_key_fingerprints"]; ok {
+		if err := json.Unmarshal(raw, &fingerprints); err != nil {
+			return nil, fmt.Errorf("invalid ssh_key_fingerprints: %v", err)
+		}
+	}
+
+	prefixes := []db.PrefixInfo{}
+	for _, key := range sortedKeys(meta) {
+		var values []string
+		if json.Unmarshal(meta[key], &values) != nil || len(values) == 0 || !allCIDRs(values) {
+			continue
+		}
+		slog.Info("Field contains CIDRs:", "field", key)
+
+		var metadata db.Metadata
+		if key == "git" && len(fingerprints) > 0 {
+			metadata = db.Metadata{"ssh_key_fingerprints": fingerprints}
+		}
+		service := key
+		for _, cidr := range values {
+			prefixes = append(prefixes, db.PrefixInfo{
+				Platform: "GitHub",
+				Prefix:   cidr,
+				Service:  &service,
+				Metadata: metadata,
+			})
+		}
+	}
+	return prefixes, nil
 }
 
-func iterateCIDRFields(g GithubResponse) (prefixes []db.PrefixInfo) {
-	v := reflect.ValueOf(g)
-	t := v.Type()
-
-	for i := 0; i < v.NumField(); i++ {
-		field := v.Field(i)
-		fieldType := t.Field(i)
-
-		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String {
-			slice := field.Interface().([]string)
-			if len(slice) > 0 && isCIDR(slice[0]) {
-				slog.Info("Field contains CIDRs:", "field", fieldType.Name)
-				for _, cidr := range slice {
-					prefixes = append(prefixes, db.PrefixInfo{
-						Platform: "GitHub",
-						Prefix:   cidr,
-						Service:  &fieldType.Name,
-					})
+// githubDomains returns the domains listed under the domains key, using each
+// key within it as the service. Keys holding an object, like
+// artifact_attestations, contribute the domains of their lists with their
+// string fields as metadata.
+func githubDomains(meta GithubResponse) ([]db.DomainInfo, error) {
+	raw, ok := meta["domains"]
+	if !ok {
+		return nil, nil
+	}
+	var services map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &services); err != nil {
+		return nil, fmt.Errorf("invalid domains: %v", err)
+	}
+
+	domains := []db.DomainInfo{}
+	for _, key := range sortedKeys(services) {
+		service := key
+		var values []string
+		if json.Unmarshal(services[key], &values) == nil {
+			for _, d := range values {
+				domains = append(domains, db.DomainInfo{Domain: d, Platform: "GitHub", Service: &service})
+			}
+			continue
+		}
+
+		var fields map[string]any
+		if json.Unmarshal(services[key], &fields) != nil {
+			slog.Warn("skipping unrecognised GitHub domains", "service", key)
+			continue
+		}
+		var metadata db.Metadata
+		values = nil
+		for name, v := range fields {
+			switch v := v.(type) {
+			case string:
+				if v != "" {
+					if metadata == nil {
+						metadata = db.Metadata{}
+					}
+					metadata[name] = v
+				}
+			case []any:
+				for _, d := range v {
+					if s, ok := d.(string); ok {
+						values = append(values, s)
+					}
 				}
 			}
 		}
+		for _, d := range values {
+			domains = append(domains, db.DomainInfo{Domain: d, Platform: "GitHub", Service: &service, Metadata: metadata})
+		}
 	}
-	return prefixes
+	return domains, nil
 }
 
-func isCIDR(s string) bool {
-	_, _, err := net.ParseCIDR(s)
-	return err == nil
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func allCIDRs(values []string) bool {
+	for _, v := range values {
+		if _, err := netip.ParsePrefix(v); err != nil {
+			return false
+		}
+	}
+	return true
 }
 
 func (m *UpdateManager) UpdateGithubPrefixes(url string) error {
-	body, err := GetJson(url)
+	return m.UpdateGithubPrefixesContext(context.Background(), url)
+}
+
+// UpdateGithubPrefixesContext stores the prefixes and domains of every
+// service in the GitHub meta API response at url.
+func (m *UpdateManager) UpdateGithubPrefixesContext(ctx context.Context, url string) error {
+	body, err := GetJsonContext(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -86,7 +147,18 @@ func (m *UpdateManager) UpdateGithubPrefixes(url string) error {
 		return err
 	}
 
-	prefixes := iterateCIDRFields(j)
+	prefixes, err := githubPrefixes(j)
+	if err != nil {
+		return err
+	}
+	domains, err := githubDomains(j)
+	if err != nil {
+		return err
+	}
 
-	return m.InsertPrefixes(prefixes)
+	err = m.InsertDomainsContext(ctx, domains)
+	if err != nil {
+		return err
+	}
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "GitHub"}, prefixes)
 }
@@ -1,7 +1,11 @@
 package update
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
 
 func TestUpdateManager_UpdateGithubPrefixes(t *testing.T) {
@@ -27,8 +31,102 @@ func TestUpdateManager_UpdateGithubPrefixes(t *testing.T) {
 			}
 
 			if !found && len(prefixes) != 1 {
-				t.Errorf("UpdateManager.UpdateAzurePrefixes() len = %d, wanted 1", len(prefixes))
+				t.Errorf("UpdateManager.UpdateGithubPrefixes() len = %d, wanted 1", len(prefixes))
 			}
 		})
 	}
 }
+
+func TestUpdateManager_UpdateGithubPrefixes_Services(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateGithubPrefixes(ts.URL() + "/github_response.json"); err != nil {
+		t.Fatalf("UpdateManager.UpdateGithubPrefixes() error = %v", err)
+	}
+
+	_, prefixes, err := manager.PrefixManager.ContainsIP("192.30.252.1")
+	if err != nil {
+		t.Fatalf("failed to query prefixes: %v", err)
+	}
+	var services []string
+	for _, p := range prefixes {
+		services = append(services, *p.Service)
+		if *p.Service == "git" {
+			fingerprints, _ := p.Metadata["ssh_key_fingerprints"].(map[string]any)
+			if fingerprints["SHA256_ED25519"] != "+DiY3wvvV6TuJJhbpZisF/zLDA0zPMSvHdkr4UvCOqU" {
+				t.Errorf("git prefix metadata = %v, want SSH key fingerprints", p.Metadata)
+			}
+		} else if p.Metadata != nil {
+			t.Errorf("%s prefix metadata = %v, want none", *p.Service, p.Metadata)
+		}
+	}
+	want := "api copilot git github_enterprise_importer hooks web"
+	if got := strings.Join(services, " "); got != want {
+		t.Errorf("UpdateManager.UpdateGithubPrefixes() services = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		domain   string
+		services []string
+		metadata db.Metadata
+	}{
+		{"*.github.com", []string{"codespaces", "copilot", "packages", "website"}, nil},
+		{"npm.pkg.github.com", []string{"packages"}, nil},
+		{"fulcio.githubapp.com", []string{"artifact_attestations"}, nil},
+		{"api.github.com", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			domains, err := manager.PrefixManager.FindDomain(tt.domain)
+			if err != nil {
+				t.Fatalf("failed to find domain: %v", err)
+			}
+			var got []string
+			for _, d := range domains {
+				if d.Platform != "GitHub" || !reflect.DeepEqual(d.Metadata, tt.metadata) {
+					t.Errorf("PrefixManager.FindDomain() = %+v", d)
+				}
+				got = append(got, *d.Service)
+			}
+			if !reflect.DeepEqual(got, tt.services) {
+				t.Errorf("PrefixManager.FindDomain() services = %v, want %v", got, tt.services)
+			}
+		})
+	}
+}
+
+func Test_githubPrefixes_NewService(t *testing.T) {
+	meta := GithubResponse{
+		"verifiable_password_authentication": []byte("false"),
+		"ssh_keys":                           []byte(`["ssh-ed25519 AAAA"]`),
+		"new_service":                        []byte(`["192.0.2.0/24", "2001:db8::/32"]`),
+		"empty":                              []byte(`[]`),
+	}
+	prefixes, err := githubPrefixes(meta)
+	if err != nil {
+		t.Fatalf("githubPrefixes() error = %v", err)
+	}
+	if len(prefixes) != 2 || *prefixes[0].Service != "new_service" || prefixes[1].Prefix != "2001:db8::/32" {
+		t.Errorf("githubPrefixes() = %+v, want new_service prefixes", prefixes)
+	}
+}
+
+func Test_githubDomains_Metadata(t *testing.T) {
+	meta := GithubResponse{
+		"domains": []byte(`{"artifact_attestations": {"trust_domain": "example", "services": ["tuf-repo.github.com"]}}`),
+	}
+	domains, err := githubDomains(meta)
+	if err != nil {
+		t.Fatalf("githubDomains() error = %v", err)
+	}
+	want := []db.DomainInfo{{
+		Domain:   "tuf-repo.github.com",
+		Platform: "GitHub",
+		Service:  stringPointer("artifact_attestations"),
+		Metadata: db.Metadata{"trust_domain": "example"},
+	}}
+	if !reflect.DeepEqual(domains, want) {
+		t.Errorf("githubDomains() = %+v, want %+v", domains, want)
+	}
+}
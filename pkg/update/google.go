@@ -1,52 +1,145 @@
 package update
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/netip"
 
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
 	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
 
+// GoogleNonCloudService is the service of Google owned ranges that customers
+// of Google Cloud can't use, i.e. those in goog.json but not cloud.json.
+const GoogleNonCloudService = "Google (non-Cloud)"
+
 type GoogleResponse struct {
-	SyncToken    string `json:"synctoken"`
+	SyncToken    string `json:"syncToken"`
 	CreationTime string `json:"creationTime"`
 	Prefixes     []struct {
-		Region     *string `json:"scaope"`
+		Region     *string `json:"scope"`
 		Service    *string `json:"service"`
 		IPv4Prefix string  `json:"ipv4Prefix"`
 		IPv6Prefix string  `json:"ipv6Prefix"`
 	} `json:"prefixes"`
 }
 
+func getGoogle(ctx context.Context, url string) (GoogleResponse, error) {
+	var j GoogleResponse
+	body, err := GetJsonContext(ctx, url)
+	if err != nil {
+		return j, err
+	}
+	err = json.Unmarshal(body, &j)
+	return j, err
+}
+
+// prefixes returns the prefix of every entry in order.
+func (j GoogleResponse) prefixes() ([]string, error) {
+	prefixes := make([]string, len(j.Prefixes))
+	for i, p := range j.Prefixes {
+		if p.IPv4Prefix != "" {
+			prefixes[i] = p.IPv4Prefix
+		} else if p.IPv6Prefix != "" {
+			prefixes[i] = p.IPv6Prefix
+		} else {
+			return nil, fmt.Errorf("unable to find prefix")
+		}
+	}
+	return prefixes, nil
+}
+
 func (m *UpdateManager) UpdateGooglePrefixes(url string, platform string) error {
-	body, err := GetJson(url)
+	return m.UpdateGooglePrefixesContext(context.Background(), url, platform)
+}
+
+func (m *UpdateManager) UpdateGooglePrefixesContext(ctx context.Context, url string, platform string) error {
+	j, err := getGoogle(ctx, url)
 	if err != nil {
 		return err
 	}
+	return m.insertGoogle(ctx, url, platform, j)
+}
 
-	var j GoogleResponse
-	err = json.Unmarshal(body, &j)
+// insertGoogle stores the entries of j, fetched from url, under platform.
+func (m *UpdateManager) insertGoogle(ctx context.Context, url string, platform string, j GoogleResponse) error {
+	cidrs, err := j.prefixes()
 	if err != nil {
 		return err
 	}
 
 	var prefixes []db.PrefixInfo
-	for _, p := range j.Prefixes {
-		var prefix string
-		if p.IPv4Prefix != "" {
-			prefix = p.IPv4Prefix
-		} else if p.IPv6Prefix != "" {
-			prefix = p.IPv6Prefix
-		} else {
-			return fmt.Errorf("unable to find prefix")
-		}
+	for i, p := range j.Prefixes {
 		prefixes = append(prefixes, db.PrefixInfo{
 			Platform: platform,
 			Region:   p.Region,
 			Service:  p.Service,
-			Prefix:   prefix,
+			Prefix:   cidrs[i],
+		})
+	}
+
+	return m.insertSource(ctx, db.Source{
+		URL:       url,
+		Platform:  platform,
+		Published: stringOrNil(j.CreationTime),
+		SyncToken: stringOrNil(j.SyncToken),
+	}, prefixes)
+}
+
+func (m *UpdateManager) UpdateGoogleNonCloudPrefixes(googURL string, cloudURL string) error {
+	return m.UpdateGoogleNonCloudPrefixesContext(context.Background(), googURL, cloudURL)
+}
+
+// UpdateGoogleNonCloudPrefixesContext stores the ranges in googURL (goog.json)
+// that aren't in cloudURL (cloud.json) under the Google platform with the
+// GoogleNonCloudService service. Google documents this difference as the
+// ranges used by its own services rather than by Cloud customers.
+func (m *UpdateManager) UpdateGoogleNonCloudPrefixesContext(ctx context.Context, googURL string, cloudURL string) error {
+	cloud, err := getGoogle(ctx, cloudURL)
+	if err != nil {
+		return err
+	}
+	return m.updateGoogleNonCloud(ctx, googURL, cloud)
+}
+
+// updateGoogleNonCloud is UpdateGoogleNonCloudPrefixesContext with cloud.json
+// already fetched, so an update that stores GCP too only fetches it once.
+func (m *UpdateManager) updateGoogleNonCloud(ctx context.Context, googURL string, cloud GoogleResponse) error {
+	goog, err := getGoogle(ctx, googURL)
+	if err != nil {
+		return err
+	}
+
+	var ranges [2][]netip.Prefix
+	for i, j := range []GoogleResponse{goog, cloud} {
+		cidrs, err := j.prefixes()
+		if err != nil {
+			return err
+		}
+		for _, c := range cidrs {
+			p, err := netip.ParsePrefix(c)
+			if err != nil {
+				return fmt.Errorf("invalid CIDR %s: %v", c, err)
+			}
+			ranges[i] = append(ranges[i], p)
+		}
+	}
+
+	service := GoogleNonCloudService
+	var prefixes []db.PrefixInfo
+	for _, p := range cidr.Subtract(ranges[0], ranges[1]) {
+		prefixes = append(prefixes, db.PrefixInfo{
+			Platform: "Google",
+			Service:  &service,
+			Prefix:   p.String(),
 		})
 	}
 
-	return m.InsertPrefixes(prefixes)
+	return m.insertSource(ctx, db.Source{
+		URL:       googURL,
+		Platform:  "Google",
+		Published: stringOrNil(goog.CreationTime),
+		SyncToken: stringOrNil(goog.SyncToken),
+	}, prefixes)
 }
@@ -1,6 +1,10 @@
 package update
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
 
 func TestUpdateManager_UpdateGooglePrefixes(t *testing.T) {
 	manager, ts, cleanup := SetupUpdateManager()
@@ -27,6 +31,65 @@ func TestUpdateManager_UpdateGooglePrefixes(t *testing.T) {
 			if !found && len(prefixes) != 2 {
 				t.Errorf("UpdateManager.UpdateAzurePrefixes() len = %d, wanted 2", len(prefixes))
 			}
+			if len(prefixes) == 0 || prefixes[0].Region == nil || *prefixes[0].Region != "africa-south1" {
+				t.Errorf("UpdateManager.UpdateGooglePrefixes() stored %+v, want region africa-south1", prefixes)
+			}
+
+			sources, err := manager.PrefixManager.ListSources()
+			if err != nil {
+				t.Fatalf("failed to list sources: %v", err)
+			}
+			if len(sources) != 1 || sources[0].SyncToken == nil || *sources[0].SyncToken != "1727467768193" {
+				t.Errorf("UpdateManager.UpdateGooglePrefixes() recorded sources %+v, want sync token 1727467768193", sources)
+			}
+		})
+	}
+}
+
+func TestUpdateManager_UpdateGoogleNonCloudPrefixes(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	err := manager.UpdateGoogleNonCloudPrefixes(ts.URL()+"/goog_response.json", ts.URL()+"/google_response.json")
+	if err != nil {
+		t.Fatalf("UpdateManager.UpdateGoogleNonCloudPrefixes() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want bool
+	}{
+		{"Google only /24", "8.8.8.8", true},
+		{"Google only /32", "2001:4860:4860::8888", true},
+		{"Left after subtracting Cloud", "34.1.64.1", true},
+		{"Cloud range", "34.1.208.1", false},
+		{"Cloud IPv6 range", "2600:1900:8000::1", false},
+		{"Left after subtracting Cloud IPv6", "2600:1900::1", true},
+		{"Not Google", "203.0.113.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, prefixes, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if found != tt.want {
+				t.Fatalf("ContainsIP(%s) = %v, want %v", tt.ip, found, tt.want)
+			}
+			for _, p := range prefixes {
+				if p.Platform != "Google" || p.Service == nil || *p.Service != GoogleNonCloudService || p.Region != nil {
+					t.Errorf("UpdateManager.UpdateGoogleNonCloudPrefixes() stored %+v", p)
+				}
+			}
 		})
 	}
+
+	infos, err := manager.PrefixManager.ListPrefixes(db.Filter{})
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	if len(infos) != 75 {
+		t.Errorf("UpdateManager.UpdateGoogleNonCloudPrefixes() stored %d prefixes, want 75", len(infos))
+	}
 }
@@ -0,0 +1,115 @@
+package update
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// IPList is a published list of plain addresses or prefixes, one per line.
+type IPList struct {
+	URL      string
+	Platform string
+	Service  string
+}
+
+// IPLists are the address lists fetched by UpdateAllSources.
+var IPLists = []IPList{
+	{"https://check.torproject.org/torbulkexitlist", "Tor", "Exit"},
+}
+
+// parseListEntry returns the prefix on a line of an address list, or false
+// for a blank or comment line. A bare address is a /32 or /128, and anything
+// following the first field, such as a trailing comment, is ignored.
+func parseListEntry(line string) (netip.Prefix, bool, error) {
+	if i := strings.IndexAny(line, "#;"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return netip.Prefix{}, false, nil
+	}
+	if strings.Contains(fields[0], "/") {
+		p, err := netip.ParsePrefix(fields[0])
+		if err != nil {
+			return netip.Prefix{}, false, err
+		}
+		return p.Masked(), true, nil
+	}
+	addr, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return netip.Prefix{}, false, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), true, nil
+}
+
+// maxListWarnings limits the malformed lines logged for each list, so a list
+// in an unexpected format doesn't flood the log.
+const maxListWarnings = 10
+
+func (m *UpdateManager) UpdateIPListPrefixes(url string, platform string, service string) error {
+	return m.UpdateIPListPrefixesContext(context.Background(), url, platform, service)
+}
+
+// UpdateIPListPrefixesContext stores the addresses of the plain list at url
+// under platform and service with the db.AnonymizerCategory category. Lists like
+// the Tor exit list change by the hour and carry no publication date, so each
+// prefix records when the list was fetched as fetched_at metadata. Lines that
+// aren't an address or prefix are skipped and logged as warnings.
+func (m *UpdateManager) UpdateIPListPrefixesContext(ctx context.Context, url string, platform string, service string) error {
+	body, err := openURL(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	fetched := time.Now().UTC().Format(time.RFC3339)
+	category := db.AnonymizerCategory
+	return m.insertSourceStream(ctx, func(insert func(db.PrefixInfo) error) (db.Source, error) {
+		source := db.Source{URL: url, Platform: platform}
+		scanner := bufio.NewScanner(body)
+		line, skipped := 0, 0
+		defer func() {
+			if skipped > 0 {
+				slog.Warn("address list entries skipped", "url", url, "count", skipped)
+			}
+		}()
+		for scanner.Scan() {
+			line++
+			prefix, ok, err := parseListEntry(scanner.Text())
+			if err != nil {
+				if skipped < maxListWarnings {
+					slog.Warn("address list warning", "url", url, "line", line, "reason", err.Error())
+				}
+				skipped++
+				continue
+			}
+			if !ok {
+				continue
+			}
+			err = insert(db.PrefixInfo{
+				Platform: platform,
+				Service:  &service,
+				Category: &category,
+				Prefix:   prefix.String(),
+				Metadata: db.Metadata{"fetched_at": fetched},
+			})
+			if err != nil {
+				return source, err
+			}
+		}
+		if ctx.Err() != nil {
+			return source, ctx.Err()
+		}
+		if err := scanner.Err(); err != nil {
+			return source, fmt.Errorf("error reading %s: %v", url, err)
+		}
+		return source, nil
+	})
+}
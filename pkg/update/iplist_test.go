@@ -0,0 +1,108 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestUpdateManager_UpdateIPListPrefixes(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		platform string
+		service  string
+		want     []string
+	}{
+		{"tor", "/torbulkexitlist.txt", "Tor", "Exit", []string{"185.220.101.1/32", "185.220.101.33/32", "2a0b:f4c2::1/128"}},
+		{"generic", "/iplist_response.txt", "Example VPN", "Exit", []string{"198.51.100.7/32", "203.0.113.0/28", "2001:db8::10/127"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager, ts, cleanup := SetupUpdateManager()
+			defer cleanup()
+
+			if err := manager.UpdateIPListPrefixes(ts.URL()+tt.file, tt.platform, tt.service); err != nil {
+				t.Fatalf("UpdateManager.UpdateIPListPrefixes() error = %v", err)
+			}
+			infos, err := manager.PrefixManager.ListPrefixes(db.Filter{Categories: []string{db.AnonymizerCategory}})
+			if err != nil {
+				t.Fatalf("failed to list prefixes: %v", err)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Prefix)
+				if info.Platform != tt.platform || *info.Service != tt.service {
+					t.Errorf("UpdateManager.UpdateIPListPrefixes() stored %+v", info)
+				}
+				fetched, _ := info.Metadata["fetched_at"].(string)
+				if _, err := time.Parse(time.RFC3339, fetched); err != nil {
+					t.Errorf("UpdateManager.UpdateIPListPrefixes() fetched_at = %v, want a time", info.Metadata["fetched_at"])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpdateManager.UpdateIPListPrefixes() stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateManager_UpdateIPListPrefixes_ExcludedByDefault(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateIPListPrefixes(ts.URL()+"/torbulkexitlist.txt", "Tor", "Exit"); err != nil {
+		t.Fatalf("UpdateManager.UpdateIPListPrefixes() error = %v", err)
+	}
+	if err := manager.InsertPrefixes([]db.PrefixInfo{{Prefix: "192.30.252.0/22", Platform: "GitHub"}}); err != nil {
+		t.Fatalf("failed to insert prefixes: %v", err)
+	}
+
+	// an unfiltered allowlist export must not admit Tor exits
+	infos, err := manager.PrefixManager.ListPrefixes(db.Filter{}.WithDefaults())
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Platform != "GitHub" {
+		t.Errorf("ListPrefixes(WithDefaults) = %+v, want only the GitHub prefix", infos)
+	}
+
+	infos, err = manager.PrefixManager.ListPrefixes(db.Filter{Platforms: []string{"Tor"}}.WithDefaults())
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	if len(infos) != 3 {
+		t.Errorf("ListPrefixes(Tor) returned %d prefixes, want 3", len(infos))
+	}
+}
+
+func TestParseListEntry(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    string
+		wantOk  bool
+		wantErr bool
+	}{
+		{"185.220.101.1", "185.220.101.1/32", true, false},
+		{"  2a0b:f4c2::1  ", "2a0b:f4c2::1/128", true, false},
+		{"203.0.113.5/28 # pool", "203.0.113.0/28", true, false},
+		{"# comment", "", false, false},
+		{"; comment", "", false, false},
+		{"", "", false, false},
+		{"not-an-address", "", false, true},
+		{"10.0.0.0/33", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok, err := parseListEntry(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseListEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk || (ok && got.String() != tt.want) {
+				t.Errorf("parseListEntry() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
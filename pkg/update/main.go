@@ -1,26 +1,55 @@
 package update
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
 )
 
 type UpdateManager struct {
-	PrefixManager *db.PrefixManager
-	GetJsonUrl    func(string, URLFinder) (string, error) // Dependency injection
+	PrefixManager     *db.PrefixManager
+	GetJsonUrl        func(string, URLFinder) (string, error)                  // Dependency injection
+	GetJsonUrlContext func(context.Context, string, URLFinder) (string, error) // Dependency injection, used in preference to GetJsonUrl
 }
 
 func NewUpdateManager(prefixManager *db.PrefixManager) *UpdateManager {
 	return &UpdateManager{PrefixManager: prefixManager}
 }
 
+// getJsonUrl calls the injected GetJsonUrlContext or GetJsonUrl, falling
+// back to GetJsonUrlContext when neither is set.
+func (m *UpdateManager) getJsonUrl(ctx context.Context, url string, finder URLFinder) (string, error) {
+	if m.GetJsonUrlContext != nil {
+		return m.GetJsonUrlContext(ctx, url, finder)
+	}
+	if m.GetJsonUrl != nil {
+		return m.GetJsonUrl(url, finder)
+	}
+	return GetJsonUrlContext(ctx, url, finder)
+}
+
+// get performs a GET request that is cancelled along with ctx.
+func get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
 func GetJsonUrl(url string, finder URLFinder) (string, error) {
-	res, err := http.Get(url)
+	return GetJsonUrlContext(context.Background(), url, finder)
+}
+
+func GetJsonUrlContext(ctx context.Context, url string, finder URLFinder) (string, error) {
+	res, err := get(ctx, url)
 	if err != nil {
 		return "", err
 	}
@@ -39,7 +68,11 @@ func GetJsonUrl(url string, finder URLFinder) (string, error) {
 }
 
 func GetJson(url string) (body []byte, err error) {
-	res, err := http.Get(url)
+	return GetJsonContext(context.Background(), url)
+}
+
+func GetJsonContext(ctx context.Context, url string) (body []byte, err error) {
+	res, err := get(ctx, url)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -56,8 +89,26 @@ func GetJson(url string) (body []byte, err error) {
 	return body, nil
 }
 
+// openURL performs a GET request for url and returns the response body,
+// for sources read as a stream rather than all at once.
+func openURL(ctx context.Context, url string) (io.ReadCloser, error) {
+	res, err := get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return res.Body, nil
+}
+
 func (m *UpdateManager) InsertPrefixes(prefixes []db.PrefixInfo) error {
-	err := m.PrefixManager.AddPrefixBatch(prefixes)
+	return m.InsertPrefixesContext(context.Background(), prefixes)
+}
+
+func (m *UpdateManager) InsertPrefixesContext(ctx context.Context, prefixes []db.PrefixInfo) error {
+	err := m.PrefixManager.AddPrefixBatchContext(ctx, prefixes)
 	if err != nil {
 		return fmt.Errorf("error inserting data %v", err)
 	}
@@ -65,76 +116,282 @@ func (m *UpdateManager) InsertPrefixes(prefixes []db.PrefixInfo) error {
 	return nil
 }
 
+func (m *UpdateManager) InsertDomains(domains []db.DomainInfo) error {
+	return m.InsertDomainsContext(context.Background(), domains)
+}
+
+func (m *UpdateManager) InsertDomainsContext(ctx context.Context, domains []db.DomainInfo) error {
+	err := m.PrefixManager.AddDomainBatchContext(ctx, domains)
+	if err != nil {
+		return fmt.Errorf("error inserting domains %v", err)
+	}
+	slog.Info("successfully inserted domains", "count", len(domains))
+	return nil
+}
+
+// insertSource inserts the prefixes fetched from source.URL and records the
+// fetch so the freshness of each source can be reported. The prefix count and
+// update time of source are filled in.
+func (m *UpdateManager) insertSource(ctx context.Context, source db.Source, prefixes []db.PrefixInfo) error {
+	err := m.InsertPrefixesContext(ctx, prefixes)
+	if err != nil {
+		return err
+	}
+	return m.recordSource(ctx, source, len(prefixes))
+}
+
+// insertChunkSize is the number of prefixes a streamed source inserts in each
+// transaction.
+const insertChunkSize = 10000
+
+// insertSourceStream is insertSource for sources too large to hold in memory.
+// read calls insert with each prefix as it is parsed, and returns the source
+// once it is done so details only known after reading can be recorded.
+// Prefixes are inserted in chunks, so those read before an error remain.
+func (m *UpdateManager) insertSourceStream(ctx context.Context, read func(insert func(db.PrefixInfo) error) (db.Source, error)) error {
+	chunk := make([]db.PrefixInfo, 0, insertChunkSize)
+	total := 0
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if err := m.PrefixManager.AddPrefixBatchContext(ctx, chunk); err != nil {
+			return fmt.Errorf("error inserting data %v", err)
+		}
+		total += len(chunk)
+		chunk = chunk[:0]
+		return nil
+	}
+
+	source, err := read(func(info db.PrefixInfo) error {
+		chunk = append(chunk, info)
+		if len(chunk) == insertChunkSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	slog.Info("successfully inserted prefixes", "count", total)
+	return m.recordSource(ctx, source, total)
+}
+
+// recordSource records a fetch of source that stored count prefixes.
+func (m *UpdateManager) recordSource(ctx context.Context, source db.Source, count int) error {
+	source.Prefixes = count
+	source.UpdatedAt = time.Now().UTC()
+	err := m.PrefixManager.SetSourceContext(ctx, source)
+	if err != nil {
+		return fmt.Errorf("error recording source %s: %v", source.URL, err)
+	}
+	return nil
+}
+
+// stringOrNil returns nil for fields a source left empty.
+func stringOrNil(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
 func (m *UpdateManager) UpdateAllSources() {
-	err := m.PrefixManager.ClearAllData()
+	if err := m.UpdateAllSourcesContext(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// UpdateAllSourcesContext replaces the contents of the database with freshly
+// fetched prefixes from every source. The prefixes are fetched into a
+// temporary database and swapped in once every source has succeeded, so if a
+// source fails or ctx is cancelled the database is left as it was. Recorded
+// ASN imports are applied again from their files.
+func (m *UpdateManager) UpdateAllSourcesContext(ctx context.Context) error {
+	f, err := os.CreateTemp("", "cloudprefixes-update-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create staging database: %v", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	staging, err := db.NewPrefixManager(f.Name())
 	if err != nil {
-		log.Fatalf("failed to clear existing data: %v", err)
+		return fmt.Errorf("failed to create staging database: %v", err)
 	}
+	defer staging.Close()
 
+	staged := *m
+	staged.PrefixManager = staging
+	if err := staged.updateAllSources(ctx); err != nil {
+		return err
+	}
+
+	// imports are recorded in the database being replaced
+	imports, err := m.PrefixManager.ListASNImportsContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list ASN imports: %v", err)
+	}
+	if err := staged.reimportASNs(ctx, imports); err != nil {
+		return err
+	}
+
+	err = m.PrefixManager.ReplaceDataContext(ctx, staging)
+	if err != nil {
+		return fmt.Errorf("failed to replace existing data: %v", err)
+	}
+	return nil
+}
+
+// skipFailed logs the failure of a source beyond the major clouds and returns
+// nil, so one vendor refusing a request or changing its format doesn't hold
+// back the update of every other source. The source's prefixes are missing
+// until the next update, apart from those a streamed source stored before it
+// failed. err is returned once ctx is cancelled.
+func skipFailed(ctx context.Context, name string, err error) error {
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	slog.Warn("skipping failed source", "source", name, "error", err)
+	return nil
+}
+
+// updateAllSources fetches every source into the database. It stops at the
+// first of GitHub, Azure, AWS, GCP and Oracle that fails or when ctx is
+// cancelled, while other sources that fail are logged and skipped.
+func (m *UpdateManager) updateAllSources(ctx context.Context) error {
 	slog.Info("Updating prefixes: GitHub")
-	err = m.UpdateGithubPrefixes("https://api.github.com/meta")
+	err := m.UpdateGithubPrefixesContext(ctx, "https://api.github.com/meta")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	slog.Info("Updating prefixes: Azure public")
-	err = m.UpdateAzurePrefixes("https://www.microsoft.com/en-us/download/details.aspx?id=56519")
+	err = m.UpdateAzurePrefixesContext(ctx, "https://www.microsoft.com/en-us/download/details.aspx?id=56519")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	slog.Info("Updating prefixes: Azure US government")
-	err = m.UpdateAzurePrefixes("https://www.microsoft.com/en-us/download/details.aspx?id=57063")
+	err = m.UpdateAzurePrefixesContext(ctx, "https://www.microsoft.com/en-us/download/details.aspx?id=57063")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	slog.Info("Updating prefixes: Azure China")
-	err = m.UpdateAzurePrefixes("https://www.microsoft.com/en-us/download/details.aspx?id=57062")
+	err = m.UpdateAzurePrefixesContext(ctx, "https://www.microsoft.com/en-us/download/details.aspx?id=57062")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	slog.Info("Updating prefixes: Azure Germany")
-	err = m.UpdateAzurePrefixes("https://www.microsoft.com/en-au/download/details.aspx?id=57064")
+	err = m.UpdateAzurePrefixesContext(ctx, "https://www.microsoft.com/en-au/download/details.aspx?id=57064")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	for _, instance := range []string{"Worldwide", "USGovDoD", "USGovGCCHigh", "China"} {
+		slog.Info("Updating prefixes: Microsoft 365", "instance", instance)
+		url := "https://endpoints.office.com/endpoints/" + instance + "?clientrequestid=" + Microsoft365ClientRequestID
+		err = skipFailed(ctx, "Microsoft 365 "+instance, m.UpdateMicrosoft365PrefixesContext(ctx, url, instance))
+		if err != nil {
+			return err
+		}
 	}
 
 	slog.Info("Updating prefixes: AWS")
-	err = m.UpdateAwsPrefixes("https://ip-ranges.amazonaws.com/ip-ranges.json")
+	err = m.UpdateAwsPrefixesContext(ctx, "https://ip-ranges.amazonaws.com/ip-ranges.json")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	slog.Info("Updating prefixes: GCP")
-	err = m.UpdateGooglePrefixes("https://www.gstatic.com/ipranges/cloud.json", "GCP")
+	cloudURL := "https://www.gstatic.com/ipranges/cloud.json"
+	cloud, err := getGoogle(ctx, cloudURL)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	err = m.insertGoogle(ctx, cloudURL, "GCP", cloud)
+	if err != nil {
+		return err
 	}
 
 	slog.Info("Updating prefixes: Google")
-	err = m.UpdateGooglePrefixes("https://www.gstatic.com/ipranges/goog.json", "Google")
+	err = skipFailed(ctx, "Google", m.updateGoogleNonCloud(ctx, "https://www.gstatic.com/ipranges/goog.json", cloud))
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	slog.Info("Updating prefixes: Oracle")
-	err = m.UpdateOraclePrefixes("https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json")
+	err = m.UpdateOraclePrefixesContext(ctx, "https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json")
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+
+	slog.Info("Updating prefixes: CloudFlare")
+	err = skipFailed(ctx, "CloudFlare", m.UpdateCloudflarePrefixesContext(ctx, "https://api.cloudflare.com/client/v4/ips?networks=jdcloud"))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Updating prefixes: Fastly")
+	err = skipFailed(ctx, "Fastly", m.UpdateFastlyPrefixesContext(ctx, "https://api.fastly.com/public-ip-list"))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range Crawlers {
+		slog.Info("Updating prefixes:", "crawler", c.Service)
+		err = skipFailed(ctx, c.Service, m.UpdateCrawlerPrefixesContext(ctx, c.URL, c.Platform, c.Service))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, l := range IPLists {
+		slog.Info("Updating prefixes:", "list", l.Platform)
+		err = skipFailed(ctx, l.Platform, m.UpdateIPListPrefixesContext(ctx, l.URL, l.Platform, l.Service))
+		if err != nil {
+			return err
+		}
 	}
 
 	geofeeds := []struct {
-		url  string
-		name string
+		url      string
+		name     string
+		category string
 	}{
 		{name: "Digial Ocean", url: "https://digitalocean.com/geo/google.csv"},
-		{name: "CloudFlare", url: "https://www.cloudflare.com/ips-v4"},
-		{name: "CloudFlare", url: "https://www.cloudflare.com/ips-v6"},
+		{name: "Apple iCloud Private Relay", url: "https://mask-api.icloud.com/egress-ip-ranges.csv", category: db.AnonymizerCategory},
 	}
 	for _, g := range geofeeds {
 		slog.Info("Updating prefixes:", "geofeed", g.name)
-		err = m.UpdateGeoFeedPrefixes(g.url, g.name)
+		err = skipFailed(ctx, g.name, m.updateGeoFeed(ctx, g.url, g.name, g.category))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, url := range DelegatedURLs {
+		slog.Info("Updating prefixes:", "delegations", url)
+		err = skipFailed(ctx, url, m.UpdateDelegatedPrefixesContext(ctx, url))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reimportASNs applies imports again. A file that has been removed since is
+// logged and skipped.
+func (m *UpdateManager) reimportASNs(ctx context.Context, imports []db.ASNImport) error {
+	for _, imp := range imports {
+		slog.Info("Updating prefixes:", "asn import", imp.Path, "platform", imp.Platform)
+		err := skipFailed(ctx, imp.Path, m.importASN(ctx, imp.Path, imp.Platform, imp.ASNs))
 		if err != nil {
-			log.Fatal(err)
+			return err
 		}
 	}
+	return nil
 }
@@ -1,7 +1,9 @@
 package update
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -73,3 +75,106 @@ func TestUpdateManager_InsertPrefixes(t *testing.T) {
 		})
 	}
 }
+
+func TestGetJsonContext_Cancelled(t *testing.T) {
+	ts := NewTestServer()
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := GetJsonContext(ctx, ts.URL()+"/aws_response.json"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetJsonContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestUpdateManager_UpdateAwsPrefixesContext_Cancelled(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := manager.UpdateAwsPrefixesContext(ctx, ts.URL()+"/aws_response.json"); err == nil {
+		t.Errorf("UpdateManager.UpdateAwsPrefixesContext() expected error for cancelled context")
+	}
+	found, _, err := manager.PrefixManager.ContainsIP("2600:1f18:6fe3:8c00::1")
+	if err != nil {
+		t.Fatalf("failed to query prefixes: %v", err)
+	}
+	if found {
+		t.Errorf("UpdateManager.UpdateAwsPrefixesContext() inserted prefixes after cancellation")
+	}
+}
+
+func TestUpdateManager_UpdateAllSourcesContext_Cancelled(t *testing.T) {
+	manager, _, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.InsertPrefixes([]db.PrefixInfo{{Prefix: "192.168.1.0/24", Platform: "AWS"}}); err != nil {
+		t.Fatalf("failed to insert prefixes: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := manager.UpdateAllSourcesContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("UpdateManager.UpdateAllSourcesContext() error = %v, want %v", err, context.Canceled)
+	}
+	found, _, err := manager.PrefixManager.ContainsIP("192.168.1.1")
+	if err != nil {
+		t.Fatalf("failed to query prefixes: %v", err)
+	}
+	if !found {
+		t.Errorf("UpdateManager.UpdateAllSourcesContext() removed existing prefixes after cancellation")
+	}
+}
+
+func TestUpdateManager_RecordsSource(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	url := ts.URL() + "/aws_response.json"
+	if err := manager.UpdateAwsPrefixes(url); err != nil {
+		t.Fatalf("UpdateManager.UpdateAwsPrefixes() error = %v", err)
+	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("UpdateManager.UpdateAwsPrefixes() recorded %d sources, want 1", len(sources))
+	}
+	s := sources[0]
+	if s.URL != url || s.Platform != "AWS" || s.Prefixes == 0 || s.UpdatedAt.IsZero() {
+		t.Errorf("UpdateManager.UpdateAwsPrefixes() recorded source %+v", s)
+	}
+	if s.Published == nil || *s.Published != "2024-09-26-14-23-08" {
+		t.Errorf("UpdateManager.UpdateAwsPrefixes() recorded published %v, want 2024-09-26-14-23-08", s.Published)
+	}
+}
+
+func Test_skipFailed(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	failed := errors.New("status code error: 403 403 Forbidden")
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		err     error
+		wantErr error
+	}{
+		{"success", context.Background(), nil, nil},
+		{"failure is skipped", context.Background(), failed, nil},
+		{"cancelled", cancelled, context.Canceled, context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := skipFailed(tt.ctx, "Example", tt.err); !errors.Is(err, tt.wantErr) {
+				t.Errorf("skipFailed() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// Microsoft365ClientRequestID identifies this tool to the Microsoft 365
+// endpoints web service, which requires a GUID with every request.
+const Microsoft365ClientRequestID = "b10c5ed9-4e39-4ce7-a1a2-9ac4e5a33d0c"
+
+// Microsoft365Response is the list of endpoint sets returned by the
+// Microsoft 365 endpoints web service for one instance, e.g.
+// https://endpoints.office.com/endpoints/Worldwide?clientrequestid=GUID
+type Microsoft365Response []struct {
+	ID                     int      `json:"id"`
+	ServiceArea            string   `json:"serviceArea"`
+	ServiceAreaDisplayName string   `json:"serviceAreaDisplayName"`
+	URLs                   []string `json:"urls"`
+	IPs                    []string `json:"ips"`
+	TCPPorts               string   `json:"tcpPorts"`
+	UDPPorts               string   `json:"udpPorts"`
+	ExpressRoute           bool     `json:"expressRoute"`
+	Category               string   `json:"category"`
+	Required               bool     `json:"required"`
+}
+
+// microsoft365Categories orders the endpoint categories from the one needing
+// the most care, Optimize, to the least.
+var microsoft365Categories = []string{"Optimize", "Allow", "Default"}
+
+// microsoft365Entry merges the endpoint sets listing the same address or URL
+// for a service area, which the web service does when they differ in ports
+// or category.
+type microsoft365Entry struct {
+	sets         []int
+	category     string
+	required     bool
+	expressRoute bool
+	tcpPorts     []string
+	udpPorts     []string
+	urls         []string
+}
+
+func (e *microsoft365Entry) add(id int, category string, required, expressRoute bool, tcpPorts, udpPorts string, urls []string) {
+	e.sets = append(e.sets, id)
+	if e.category == "" || categoryRank(category) < categoryRank(e.category) {
+		e.category = category
+	}
+	e.required = e.required || required
+	e.expressRoute = e.expressRoute || expressRoute
+	e.tcpPorts = appendUnique(e.tcpPorts, splitPorts(tcpPorts)...)
+	e.udpPorts = appendUnique(e.udpPorts, splitPorts(udpPorts)...)
+	e.urls = appendUnique(e.urls, urls...)
+}
+
+func (e *microsoft365Entry) metadata() db.Metadata {
+	metadata := db.Metadata{
+		"category":         e.category,
+		"required":         e.required,
+		"express_route":    e.expressRoute,
+		"endpoint_set_ids": e.sets,
+	}
+	if len(e.tcpPorts) > 0 {
+		metadata["tcp_ports"] = strings.Join(e.tcpPorts, ",")
+	}
+	if len(e.udpPorts) > 0 {
+		metadata["udp_ports"] = strings.Join(e.udpPorts, ",")
+	}
+	if len(e.urls) > 0 {
+		metadata["urls"] = e.urls
+	}
+	return metadata
+}
+
+// categoryRank returns the position of category in microsoft365Categories,
+// with unknown categories last.
+func categoryRank(category string) int {
+	if i := slices.Index(microsoft365Categories, category); i >= 0 {
+		return i
+	}
+	return len(microsoft365Categories)
+}
+
+// splitPorts splits a comma separated list of ports and port ranges.
+func splitPorts(ports string) []string {
+	var result []string
+	for _, p := range strings.Split(ports, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func appendUnique(values []string, add ...string) []string {
+	for _, v := range add {
+		if !slices.Contains(values, v) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+type microsoft365Key struct {
+	serviceArea string
+	value       string
+}
+
+// microsoft365Entries holds merged entries in the order first listed.
+type microsoft365Entries struct {
+	keys    []microsoft365Key
+	entries map[microsoft365Key]*microsoft365Entry
+}
+
+func (es *microsoft365Entries) get(serviceArea, value string) *microsoft365Entry {
+	k := microsoft365Key{serviceArea, value}
+	if es.entries == nil {
+		es.entries = map[microsoft365Key]*microsoft365Entry{}
+	}
+	e, ok := es.entries[k]
+	if !ok {
+		e = &microsoft365Entry{}
+		es.entries[k] = e
+		es.keys = append(es.keys, k)
+	}
+	return e
+}
+
+// microsoft365Endpoints returns a prefix for each address and a domain for
+// each URL of every service area in j, merging the endpoint sets that list
+// them. The prefixes carry the URLs of their endpoint sets as metadata.
+func microsoft365Endpoints(j Microsoft365Response, instance string) ([]db.PrefixInfo, []db.DomainInfo) {
+	var ips, urls microsoft365Entries
+	for _, set := range j {
+		for _, ip := range set.IPs {
+			ips.get(set.ServiceArea, ip).add(set.ID, set.Category, set.Required, set.ExpressRoute, set.TCPPorts, set.UDPPorts, set.URLs)
+		}
+		for _, url := range set.URLs {
+			urls.get(set.ServiceArea, url).add(set.ID, set.Category, set.Required, set.ExpressRoute, set.TCPPorts, set.UDPPorts, nil)
+		}
+	}
+
+	prefixes := make([]db.PrefixInfo, 0, len(ips.keys))
+	for _, k := range ips.keys {
+		service := k.serviceArea
+		prefixes = append(prefixes, db.PrefixInfo{
+			Platform: "Microsoft 365",
+			Prefix:   k.value,
+			Service:  &service,
+			Region:   &instance,
+			Metadata: ips.entries[k].metadata(),
+		})
+	}
+	domains := make([]db.DomainInfo, 0, len(urls.keys))
+	for _, k := range urls.keys {
+		service := k.serviceArea
+		domains = append(domains, db.DomainInfo{
+			Platform: "Microsoft 365",
+			Domain:   k.value,
+			Service:  &service,
+			Region:   &instance,
+			Metadata: urls.entries[k].metadata(),
+		})
+	}
+	return prefixes, domains
+}
+
+func (m *UpdateManager) UpdateMicrosoft365Prefixes(url, instance string) error {
+	return m.UpdateMicrosoft365PrefixesContext(context.Background(), url, instance)
+}
+
+// UpdateMicrosoft365PrefixesContext stores the addresses and URLs of the
+// Microsoft 365 instance, e.g. Worldwide, USGovDoD, USGovGCCHigh or China,
+// published by the endpoints web service at url. The instance is stored as
+// the region and the service area (Exchange, SharePoint, Skype or Common) as
+// the service.
+func (m *UpdateManager) UpdateMicrosoft365PrefixesContext(ctx context.Context, url, instance string) error {
+	body, err := GetJsonContext(ctx, url)
+	if err != nil {
+		return err
+	}
+
+	var j Microsoft365Response
+	err = json.Unmarshal(body, &j)
+	if err != nil {
+		return err
+	}
+
+	prefixes, domains := microsoft365Endpoints(j, instance)
+
+	err = m.InsertDomainsContext(ctx, domains)
+	if err != nil {
+		return err
+	}
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "Microsoft 365"}, prefixes)
+}
@@ -0,0 +1,130 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestUpdateManager_UpdateMicrosoft365Prefixes(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	if err := manager.UpdateMicrosoft365Prefixes(ts.URL()+"/microsoft365_response.json", "Worldwide"); err != nil {
+		t.Fatalf("UpdateManager.UpdateMicrosoft365Prefixes() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ip   string
+		want []db.PrefixInfo
+	}{
+		{
+			"Merged endpoint sets",
+			"40.96.0.1",
+			[]db.PrefixInfo{{
+				Prefix:   "40.96.0.0/13",
+				Platform: "Microsoft 365",
+				Region:   stringPointer("Worldwide"),
+				Service:  stringPointer("Exchange"),
+				Metadata: db.Metadata{
+					"category":         "Optimize",
+					"required":         true,
+					"express_route":    true,
+					"endpoint_set_ids": []any{float64(1), float64(2)},
+					"tcp_ports":        "80,443,587",
+					"udp_ports":        "443",
+					"urls":             []any{"outlook.office.com", "outlook.office365.com", "*.protection.outlook.com"},
+				},
+			}},
+		},
+		{
+			"IPv6 without URLs",
+			"2603:1063::1",
+			[]db.PrefixInfo{{
+				Prefix:   "2603:1063::/38",
+				Platform: "Microsoft 365",
+				Region:   stringPointer("Worldwide"),
+				Service:  stringPointer("Skype"),
+				Metadata: db.Metadata{
+					"category":         "Optimize",
+					"required":         true,
+					"express_route":    true,
+					"endpoint_set_ids": []any{float64(11)},
+					"udp_ports":        "3478,3479,3480,3481",
+				},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UpdateManager.UpdateMicrosoft365Prefixes() stored %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	domains, err := manager.PrefixManager.MatchDomain("contoso-my.sharepoint.com")
+	if err != nil {
+		t.Fatalf("failed to match domain: %v", err)
+	}
+	if len(domains) != 2 || *domains[0].Service != "SharePoint" || domains[0].Metadata["category"] != "Optimize" {
+		t.Errorf("PrefixManager.MatchDomain() = %+v, want the SharePoint wildcards", domains)
+	}
+
+	// a URL only in a Default endpoint set is stored without addresses
+	domains, err = manager.PrefixManager.FindDomain("*.officeapps.live.com")
+	if err != nil {
+		t.Fatalf("failed to find domain: %v", err)
+	}
+	if len(domains) != 1 || domains[0].Metadata["category"] != "Default" || domains[0].Metadata["required"] != false {
+		t.Errorf("PrefixManager.FindDomain() = %+v, want the Default Common URL", domains)
+	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Platform != "Microsoft 365" || sources[0].Prefixes != 12 {
+		t.Errorf("UpdateManager.UpdateMicrosoft365Prefixes() recorded sources %+v, want 12 prefixes", sources)
+	}
+}
+
+func TestUpdateManager_UpdateMicrosoft365Prefixes_Instances(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	instances := []string{"Worldwide", "USGovDoD"}
+	for _, instance := range instances {
+		if err := manager.UpdateMicrosoft365Prefixes(ts.URL()+"/microsoft365_response.json", instance); err != nil {
+			t.Fatalf("UpdateManager.UpdateMicrosoft365Prefixes(%s) error = %v", instance, err)
+		}
+	}
+
+	domains, err := manager.PrefixManager.FindDomain("login.microsoftonline.com")
+	if err != nil {
+		t.Fatalf("failed to find domain: %v", err)
+	}
+	var got []string
+	for _, d := range domains {
+		got = append(got, *d.Region)
+	}
+	// ordered by region
+	want := []string{"USGovDoD", "Worldwide"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PrefixManager.FindDomain() regions = %v, want %v", got, want)
+	}
+
+	_, prefixes, err := manager.PrefixManager.ContainsIP("40.96.0.1")
+	if err != nil {
+		t.Fatalf("failed to query prefixes: %v", err)
+	}
+	if len(prefixes) != 2 {
+		t.Errorf("ContainsIP() len = %d, want one per instance", len(prefixes))
+	}
+}
@@ -1,6 +1,7 @@
 package update
 
 import (
+	"context"
 	"encoding/json"
 
 	"github.com/mchaffe/cloudprefixes/pkg/db"
@@ -18,7 +19,11 @@ type OracleResponse struct {
 }
 
 func (m *UpdateManager) UpdateOraclePrefixes(url string) error {
-	body, err := GetJson(url)
+	return m.UpdateOraclePrefixesContext(context.Background(), url)
+}
+
+func (m *UpdateManager) UpdateOraclePrefixesContext(ctx context.Context, url string) error {
+	body, err := GetJsonContext(ctx, url)
 	if err != nil {
 		return err
 	}
@@ -43,5 +48,5 @@ func (m *UpdateManager) UpdateOraclePrefixes(url string) error {
 		}
 	}
 
-	return m.InsertPrefixes(prefixes)
+	return m.insertSource(ctx, db.Source{URL: url, Platform: "Oracle", Published: stringOrNil(j.LastUpdatedTimestamp)}, prefixes)
 }
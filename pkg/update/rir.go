@@ -0,0 +1,161 @@
+package update
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/mchaffe/cloudprefixes/pkg/cidr"
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+// DelegatedURLs are the extended delegation statistics of each regional
+// internet registry.
+var DelegatedURLs = []string{
+	"https://ftp.arin.net/pub/stats/arin/delegated-arin-extended-latest",
+	"https://ftp.ripe.net/pub/stats/ripencc/delegated-ripencc-extended-latest",
+	"https://ftp.apnic.net/stats/apnic/delegated-apnic-extended-latest",
+	"https://ftp.lacnic.net/pub/stats/lacnic/delegated-lacnic-extended-latest",
+	"https://ftp.afrinic.net/pub/stats/afrinic/delegated-afrinic-extended-latest",
+}
+
+// delegationPrefixes returns the prefixes of a delegated-extended record,
+// registry|cc|type|start|value|date|status[|opaque-id[|extensions]], or nil
+// for records other than allocated or assigned address space. For IPv4 the
+// value is a count of addresses, which need not be a power of two, and for
+// IPv6 a prefix length.
+func delegationPrefixes(fields []string) ([]db.PrefixInfo, error) {
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("expected at least 7 fields, got %d", len(fields))
+	}
+	registry, country, kind, start, value, date, status := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+	if (kind != "ipv4" && kind != "ipv6") || (status != "allocated" && status != "assigned") {
+		return nil, nil
+	}
+
+	first, err := netip.ParseAddr(start)
+	if err != nil {
+		return nil, err
+	}
+	var prefixes []netip.Prefix
+	if kind == "ipv4" {
+		count, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || count == 0 || !first.Is4() {
+			return nil, fmt.Errorf("invalid IPv4 range %s+%s", start, value)
+		}
+		b := first.As4()
+		end := uint64(binary.BigEndian.Uint32(b[:])) + count - 1
+		if end > 0xffffffff {
+			return nil, fmt.Errorf("invalid IPv4 range %s+%s", start, value)
+		}
+		binary.BigEndian.PutUint32(b[:], uint32(end))
+		prefixes = cidr.FromRange(first, netip.AddrFrom4(b))
+	} else {
+		bits, err := strconv.Atoi(value)
+		if err != nil || !first.Is6() {
+			return nil, fmt.Errorf("invalid IPv6 prefix %s/%s", start, value)
+		}
+		p, err := first.Prefix(bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IPv6 prefix %s/%s", start, value)
+		}
+		prefixes = []netip.Prefix{p}
+	}
+
+	// ZZ marks space not yet attributed to a country
+	if country == "ZZ" {
+		country = ""
+	}
+	metadata := db.Metadata{"registry": registry, "status": status}
+	if country != "" {
+		metadata["country_code"] = country
+	}
+	if len(date) == 8 && date != "00000000" {
+		metadata["date"] = date[:4] + "-" + date[4:6] + "-" + date[6:]
+	}
+	if len(fields) > 7 && fields[7] != "" {
+		metadata["opaque_id"] = fields[7]
+	}
+
+	category := db.RegistryCategory
+	infos := make([]db.PrefixInfo, 0, len(prefixes))
+	for _, p := range prefixes {
+		infos = append(infos, db.PrefixInfo{
+			Platform: "RIR",
+			Service:  &registry,
+			Region:   stringOrNil(country),
+			Category: &category,
+			Prefix:   p.String(),
+			Metadata: metadata,
+		})
+	}
+	return infos, nil
+}
+
+func (m *UpdateManager) UpdateDelegatedPrefixes(url string) error {
+	return m.UpdateDelegatedPrefixesContext(context.Background(), url)
+}
+
+// UpdateDelegatedPrefixesContext stores the allocated and assigned address
+// space in the RIR extended delegation statistics file at url, so the
+// registered country of any address can be reported. Prefixes are stored
+// under the RIR platform with the registry as the service, the country code as
+// the region, the db.RegistryCategory category and the registry, country_code,
+// date and status of the delegation as metadata. The file is read as a stream.
+func (m *UpdateManager) UpdateDelegatedPrefixesContext(ctx context.Context, url string) error {
+	body, err := openURL(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return m.insertSourceStream(ctx, func(insert func(db.PrefixInfo) error) (db.Source, error) {
+		source := db.Source{URL: url, Platform: "RIR"}
+		scanner := bufio.NewScanner(body)
+		line := 0
+		version := false
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			fields := strings.Split(text, "|")
+
+			// the version line comes first,
+			// version|registry|serial|records|startdate|enddate|UTCoffset
+			if !version {
+				version = true
+				if len(fields) >= 6 {
+					source.Published = stringOrNil(fields[5])
+				}
+				continue
+			}
+			// then a summary line for each type, registry|*|type|*|count|summary
+			if len(fields) >= 6 && fields[5] == "summary" {
+				continue
+			}
+
+			infos, err := delegationPrefixes(fields)
+			if err != nil {
+				return source, fmt.Errorf("error reading %s line %d: %v", url, line, err)
+			}
+			for _, info := range infos {
+				if err := insert(info); err != nil {
+					return source, err
+				}
+			}
+		}
+		if ctx.Err() != nil {
+			return source, ctx.Err()
+		}
+		if err := scanner.Err(); err != nil {
+			return source, fmt.Errorf("error reading %s: %v", url, err)
+		}
+		return source, nil
+	})
+}
@@ -0,0 +1,112 @@
+package update
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+)
+
+func TestUpdateManager_UpdateDelegatedPrefixes(t *testing.T) {
+	manager, ts, cleanup := SetupUpdateManager()
+	defer cleanup()
+
+	url := ts.URL() + "/delegated_response.txt"
+	if err := manager.UpdateDelegatedPrefixes(url); err != nil {
+		t.Fatalf("UpdateManager.UpdateDelegatedPrefixes() error = %v", err)
+	}
+
+	infos, err := manager.PrefixManager.ListPrefixes(db.Filter{Categories: []string{db.RegistryCategory}})
+	if err != nil {
+		t.Fatalf("failed to list prefixes: %v", err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, info.Prefix)
+	}
+	// 6144 addresses from 193.0.0.0 don't fit in a single prefix
+	want := []string{"2.16.0.0/24", "193.0.0.0/20", "193.0.16.0/21", "2001:67c:2e8::/48"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UpdateManager.UpdateDelegatedPrefixes() stored %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		ip   string
+		want []db.PrefixInfo
+	}{
+		{
+			"193.0.20.1",
+			[]db.PrefixInfo{{
+				Prefix:   "193.0.16.0/21",
+				Platform: "RIR",
+				Service:  stringPointer("ripencc"),
+				Region:   stringPointer("NL"),
+				Category: stringPointer(db.RegistryCategory),
+				Metadata: db.Metadata{
+					"registry":     "ripencc",
+					"country_code": "NL",
+					"date":         "1992-04-14",
+					"status":       "allocated",
+					"opaque_id":    "d2a5a7c1-5d7b-4b0e-8f52-98c1f7f2a111",
+				},
+			}},
+		},
+		{
+			"5.101.136.1",
+			[]db.PrefixInfo{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			_, got, err := manager.PrefixManager.ContainsIP(tt.ip)
+			if err != nil {
+				t.Fatalf("failed to query prefixes: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ContainsIP(%s) = %+v, want %+v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	sources, err := manager.PrefixManager.ListSources()
+	if err != nil {
+		t.Fatalf("failed to list sources: %v", err)
+	}
+	if len(sources) != 1 || sources[0].Prefixes != 4 || !reflect.DeepEqual(sources[0].Published, stringPointer("20240927")) {
+		t.Errorf("ListSources() = %+v, want 4 prefixes published 20240927", sources)
+	}
+}
+
+func TestDelegationPrefixes(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    []string
+		want    []string
+		wantErr bool
+	}{
+		{"asn", []string{"arin", "US", "asn", "701", "1", "19900803", "allocated"}, nil, false},
+		{"available", []string{"arin", "", "ipv4", "23.128.0.0", "1024", "", "available"}, nil, false},
+		{"ipv4", []string{"arin", "US", "ipv4", "3.0.0.0", "16777216", "20170925", "allocated"}, []string{"3.0.0.0/8"}, false},
+		{"ipv4 unaligned", []string{"apnic", "JP", "ipv4", "1.0.16.0", "768", "20110412", "allocated"}, []string{"1.0.16.0/23", "1.0.18.0/24"}, false},
+		{"ipv6", []string{"afrinic", "ZA", "ipv6", "2c0f:f000::", "32", "20080612", "allocated"}, []string{"2c0f:f000::/32"}, false},
+		{"short", []string{"lacnic", "BR", "ipv4"}, nil, true},
+		{"bad count", []string{"lacnic", "BR", "ipv4", "200.0.0.0", "0", "19960101", "allocated"}, nil, true},
+		{"overflow", []string{"lacnic", "BR", "ipv4", "255.255.255.0", "512", "19960101", "allocated"}, nil, true},
+		{"bad address", []string{"lacnic", "BR", "ipv6", "200.0.0.0", "32", "19960101", "allocated"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := delegationPrefixes(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("delegationPrefixes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Prefix)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("delegationPrefixes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mchaffe/cloudprefixes/pkg/db"
+	"github.com/mchaffe/cloudprefixes/pkg/report"
+)
+
+func runStats(ctx context.Context, args []string) error {
+	flags, databasePath := newFlagSet("stats", "", "Summarise the prefixes in the database and when each source was last updated")
+	format := flags.String("format", "table", "output format, table or json")
+	filter := filterFlags(flags)
+	flags.Parse(args)
+
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format %q, use table or json", *format)
+	}
+
+	manager, err := openExistingDB(*databasePath)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	infos, err := manager.ListPrefixesContext(ctx, filter.WithDefaults())
+	if err != nil {
+		return fmt.Errorf("error reading prefixes: %v", err)
+	}
+	sources, err := manager.ListSourcesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading sources: %v", err)
+	}
+	if len(filter.Platforms) > 0 {
+		sources = slices.DeleteFunc(sources, func(s db.Source) bool {
+			return !slices.Contains(filter.Platforms, s.Platform)
+		})
+	}
+
+	r, err := report.Stats(infos, sources)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	writeCounts(w, "PLATFORM", r.Platforms, func(c report.Count) string { return c.Platform })
+	total := r.Total
+	total.Platform = "TOTAL"
+	writeCounts(w, "", []report.Count{total}, func(c report.Count) string { return c.Platform })
+	fmt.Fprintln(w)
+	writeCounts(w, "PLATFORM\tSERVICE", r.Services, func(c report.Count) string { return c.Platform + "\t" + orDash(c.Service) })
+	fmt.Fprintln(w)
+	writeCounts(w, "PLATFORM\tREGION", r.Regions, func(c report.Count) string { return c.Platform + "\t" + orDash(c.Region) })
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "PLATFORM\tURL\tPREFIXES\tPUBLISHED\tUPDATED")
+	for _, s := range r.Sources {
+		published := "-"
+		if s.Published != nil {
+			published = *s.Published
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s (%s ago)\n",
+			s.Platform, s.URL, s.Prefixes, published,
+			s.UpdatedAt.Local().Format(time.DateTime), time.Since(s.UpdatedAt).Round(time.Minute))
+	}
+	return w.Flush()
+}
+
+// writeCounts writes a table of counts whose leading columns, named by
+// header, are given by name. An empty header continues the previous table.
+func writeCounts(w io.Writer, header string, counts []report.Count, name func(report.Count) string) {
+	if header != "" {
+		fmt.Fprintf(w, "%s\tPREFIXES\tIPV4\tIPV6\tIPV4 /24s\tIPV6 /48s\n", header)
+	}
+	for _, c := range counts {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t%s\n",
+			name(c), c.Prefixes, c.IPv4Prefixes, c.IPv6Prefixes, formatSpace(c.IPv4Slash24s), formatSpace(c.IPv6Slash48s))
+	}
+}
+
+// formatSpace formats an address space count, which is fractional for groups
+// of prefixes longer than /24 or /48.
+func formatSpace(f float64) string {
+	if f == math.Trunc(f) {
+		return fmt.Sprintf("%.0f", f)
+	}
+	if f < 0.01 {
+		return "<0.01"
+	}
+	return fmt.Sprintf("%.2f", f)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}